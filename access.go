@@ -0,0 +1,46 @@
+package mesh
+
+import "errors"
+
+// SendAccess sends an access message with a 1 or 2 byte SIG opcode and raw params to the given addr
+// 1 byte opcodes are 0x00 to 0x7E and 2 byte opcodes 0x8000 to 0xBFFF, vendor opcodes are sent with SendVendorAccess
+// OpSendMessage only carries an on off state so the message is sent with OpSendAccessMessage
+// Each frame is OpSendAccessMessage, the opcode as sent over the air, params, the addr then the app key index
+func (controller *Controller) SendAccess(addr uint16, appIdx uint16, opcode uint16, params []byte) error {
+	var encoded []byte
+	switch {
+	case opcode < 0x7F:
+		encoded = []byte{byte(opcode)}
+	case opcode >= 0x8000 && opcode < 0xC000:
+		// Multi byte opcodes are big endian unlike the rest of the frame
+		encoded = []byte{byte(opcode >> 8), byte(opcode)}
+	default:
+		return errors.New("Invalid opcode")
+	}
+	return controller.sendAccess(addr, appIdx, encoded, params)
+}
+
+// SendVendorAccess sends an access message with a 3 byte vendor opcode and raw params to the given addr
+// opcode is the low 6 bits of the first opcode byte as passed to onVendorEvent
+func (controller *Controller) SendVendorAccess(addr uint16, appIdx uint16, companyID uint16, opcode byte, params []byte) error {
+	if opcode > 0x3F {
+		return errors.New("Invalid vendor opcode")
+	}
+	encoded := []byte{0xC0 | opcode}
+	encoded = append(encoded, toByteSlice(companyID)...)
+	return controller.sendAccess(addr, appIdx, encoded, params)
+}
+
+// sendAccess writes an OpSendAccessMessage frame with an encoded opcode
+func (controller *Controller) sendAccess(addr uint16, appIdx uint16, opcode []byte, params []byte) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
+	parms := []byte{OpSendAccessMessage}
+	parms = append(parms, opcode...)
+	parms = append(parms, params...)
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
@@ -0,0 +1,64 @@
+package mesh
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff sets how failed usb writes are retried, zero fields use the defaults
+// Each retry waits Factor times longer than the last up to Max with up to half of each delay randomized
+// so writes that failed together do not all retry at once
+type Backoff struct {
+	// Base is the delay before the first retry, 200ms by default
+	Base time.Duration
+	// Max caps the delay between retries, 2s by default
+	Max time.Duration
+	// Factor is how much the delay grows after each retry, 2 by default
+	Factor float64
+	// Retries is how many times a write is retried, 1 by default and NoRetries to never retry
+	Retries int
+}
+
+// NoRetries set as Backoff.Retries makes failed writes return without being retried
+const NoRetries = -1
+
+// Default backoff settings
+const (
+	defaultBackoffBase    = 200 * time.Millisecond
+	defaultBackoffMax     = 2 * time.Second
+	defaultBackoffFactor  = 2
+	defaultBackoffRetries = 1
+)
+
+// withDefaults returns backoff with zero fields set to their defaults
+func (backoff Backoff) withDefaults() Backoff {
+	if backoff.Base <= 0 {
+		backoff.Base = defaultBackoffBase
+	}
+	if backoff.Max <= 0 {
+		backoff.Max = defaultBackoffMax
+	}
+	if backoff.Factor < 1 {
+		backoff.Factor = defaultBackoffFactor
+	}
+	if backoff.Retries == 0 {
+		backoff.Retries = defaultBackoffRetries
+	} else if backoff.Retries < 0 {
+		backoff.Retries = 0
+	}
+	return backoff
+}
+
+// delay returns how long to wait before the given retry counting from 0
+func (backoff Backoff) delay(retry int) time.Duration {
+	d := float64(backoff.Base)
+	for i := 0; i < retry && d < float64(backoff.Max); i++ {
+		d *= backoff.Factor
+	}
+	if d > float64(backoff.Max) {
+		d = float64(backoff.Max)
+	}
+	// Keep half the delay and randomize the rest
+	half := time.Duration(d / 2)
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
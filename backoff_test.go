@@ -0,0 +1,113 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/gousb"
+)
+
+func TestBackoffDefaults(t *testing.T) {
+	backoff := Backoff{}.withDefaults()
+	if backoff.Base != defaultBackoffBase || backoff.Max != defaultBackoffMax ||
+		backoff.Factor != defaultBackoffFactor || backoff.Retries != defaultBackoffRetries {
+		t.Fatalf("got %+v", backoff)
+	}
+}
+
+func TestBackoffDelays(t *testing.T) {
+	backoff := Backoff{Base: 10 * time.Millisecond, Max: 100 * time.Millisecond, Factor: 2, Retries: 6}.withDefaults()
+	// Full delays for 6 failing writes, the last two are capped at Max
+	want := []time.Duration{10, 20, 40, 80, 100, 100}
+	for retry, full := range want {
+		full *= time.Millisecond
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			delay := backoff.delay(retry)
+			if delay < full/2 || delay > full {
+				t.Fatalf("retry %d delay %v outside [%v, %v]", retry, delay, full/2, full)
+			}
+			seen[delay] = true
+		}
+		if len(seen) < 2 {
+			t.Fatalf("retry %d delays are not jittered", retry)
+		}
+	}
+}
+
+// fakeEndpoint fails writes with errs in order then records the packets written
+// onWrite is called with each written packet
+type fakeEndpoint struct {
+	errs    []error
+	writes  int
+	packets [][]byte
+	onWrite func(packet []byte)
+}
+
+func (endpoint *fakeEndpoint) WriteContext(ctx context.Context, buf []byte) (int, error) {
+	endpoint.writes++
+	if len(endpoint.errs) > 0 {
+		err := endpoint.errs[0]
+		endpoint.errs = endpoint.errs[1:]
+		return 0, err
+	}
+	endpoint.packets = append(endpoint.packets, buf)
+	if endpoint.onWrite != nil {
+		endpoint.onWrite(buf)
+	}
+	return len(buf), nil
+}
+
+func TestWritePacketRetries(t *testing.T) {
+	timeouts := func(n int) []error {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = gousb.ErrorTimeout
+		}
+		return errs
+	}
+	tests := []struct {
+		name    string
+		retries int
+		errs    []error
+		writes  int
+		err     error
+	}{
+		{"succeeds after retries", 3, timeouts(2), 3, nil},
+		{"fails after all retries", 3, timeouts(10), 4, errors.New("Write failed")},
+		{"default retries once", 0, timeouts(10), 2, errors.New("Write failed")},
+		{"no retries", NoRetries, timeouts(10), 1, errors.New("Write failed")},
+		{"unplugged is not retried", 3, []error{gousb.ErrorNoDevice}, 1, ErrControllerNotFound},
+		{"gone mid retry", 3, []error{gousb.ErrorTimeout, gousb.TransferNoDevice}, 2, ErrControllerNotFound},
+	}
+	for _, test := range tests {
+		controller := newController(DefaultOpenConfig(), nil)
+		endpoint := &fakeEndpoint{errs: test.errs}
+		controller.epOut = endpoint
+		controller.Backoff = Backoff{Base: time.Millisecond, Max: 2 * time.Millisecond, Retries: test.retries}
+		err := controller.writePacket([]byte{OpReboot})
+		if endpoint.writes != test.writes {
+			t.Errorf("%s: got %d writes, want %d", test.name, endpoint.writes, test.writes)
+		}
+		if (err == nil) != (test.err == nil) || (err != nil && err.Error() != test.err.Error()) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestWritePacketDelayCappedAtMax(t *testing.T) {
+	controller := newController(DefaultOpenConfig(), nil)
+	controller.epOut = &fakeEndpoint{errs: []error{gousb.ErrorBusy, gousb.ErrorBusy, gousb.ErrorBusy, gousb.ErrorBusy}}
+	// Uncapped the retries would wait 1ms, 100ms, 10s then 1000s
+	controller.Backoff = Backoff{Base: time.Millisecond, Max: 5 * time.Millisecond, Factor: 100, Retries: 4}
+	start := time.Now()
+	err := controller.writePacket([]byte{OpReboot})
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("retries took %v, delays were not capped", elapsed)
+	}
+}
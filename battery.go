@@ -0,0 +1,46 @@
+package mesh
+
+import "context"
+
+// Special generic battery values from the mesh spec
+const (
+	// BatteryLevelUnknown is the level of a battery whose charge is not known
+	BatteryLevelUnknown = 0xFF
+	// BatteryTimeUnknown is the discharge or charge time of a battery that can not estimate it
+	BatteryTimeUnknown = 0xFFFFFF
+)
+
+// BatteryStatus is the state of the generic battery server of a node
+// Level is a percentage from 0 to 100 and the times are in minutes
+// The flags are the 2 bit presence, indicator, charging and serviceability states from the mesh spec
+type BatteryStatus struct {
+	Level           uint8
+	TimeToDischarge uint32
+	TimeToCharge    uint32
+	Presence        uint8
+	Indicator       uint8
+	Charging        uint8
+	Serviceability  uint8
+}
+
+// GetBattery reads the battery state of the node with the given addr using the app key at the given index
+func (controller *Controller) GetBattery(ctx context.Context, addr uint16, appIdx uint16) (BatteryStatus, error) {
+	parms := []byte{OpGetBattery}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpBatteryStatus, addr)
+	if err != nil {
+		return BatteryStatus{}, err
+	}
+	// Reply is addr, level, 24 bit time to discharge, 24 bit time to charge then flags
+	flags := reply[10]
+	return BatteryStatus{
+		Level:           reply[3],
+		TimeToDischarge: uint32(reply[4]) | uint32(reply[5])<<8 | uint32(reply[6])<<16,
+		TimeToCharge:    uint32(reply[7]) | uint32(reply[8])<<8 | uint32(reply[9])<<16,
+		Presence:        flags & 0x03,
+		Indicator:       flags >> 2 & 0x03,
+		Charging:        flags >> 4 & 0x03,
+		Serviceability:  flags >> 6 & 0x03,
+	}, nil
+}
@@ -0,0 +1,46 @@
+package mesh
+
+import (
+	"sync"
+	"time"
+)
+
+// busyTimeout is how long the controller is treated as busy if it never reports being ready
+const busyTimeout = provisionTimeout
+
+// busyExemptOps can be written while the controller is busy
+var busyExemptOps = map[byte]bool{
+	OpReset:             true,
+	OpReboot:            true,
+	OpStartProvisioning: true,
+	OpAbortProvisioning: true,
+	OpEnterOOB:          true,
+}
+
+// busyState tracks when the controller is dropping commands
+// It is set by OpBusy or starting to provision and cleared by OpReady or OpNodeAdded
+type busyState struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// set marks the controller busy until cleared or busyTimeout passes
+func (busy *busyState) set() {
+	busy.mu.Lock()
+	busy.until = time.Now().Add(busyTimeout)
+	busy.mu.Unlock()
+}
+
+// clear marks the controller ready
+func (busy *busyState) clear() {
+	busy.mu.Lock()
+	busy.until = time.Time{}
+	busy.mu.Unlock()
+}
+
+// isBusy reports if the controller is busy
+func (busy *busyState) isBusy() bool {
+	busy.mu.Lock()
+	defer busy.mu.Unlock()
+	return time.Now().Before(busy.until)
+}
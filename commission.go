@@ -0,0 +1,80 @@
+package mesh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Commission adds the device with the given uuid to the network and makes it ready to use
+// It waits for the device's beacon, provisions it, binds the app key at appIdx and adds each elem to groupAddr
+// If a step after provisioning fails the node is reset so the device can be commissioned again
+// When the reset fails too the returned error wraps both errors
+// Read must be running for the replies to be received
+func (controller *Controller) Commission(ctx context.Context, uuid []byte, appIdx uint16, groupAddr uint16) (NodeInfo, error) {
+	if len(uuid) != 16 {
+		return NodeInfo{}, errors.New("Invalid uuid")
+	}
+	err := controller.waitForBeacon(ctx, uuid)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	node, err := controller.provisionAndWait(ctx, uuid)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	err = controller.configureAdded(ctx, node, appIdx, groupAddr)
+	if err != nil {
+		// Roll back so the device is unprovisioned again
+		resetErr := controller.ResetNode(node.Addr)
+		if resetErr != nil {
+			return NodeInfo{}, fmt.Errorf("%w, then unable to reset node %d: %w", err, node.Addr, resetErr)
+		}
+		return NodeInfo{}, err
+	}
+	node.AppKeys = []uint16{appIdx}
+	return node, nil
+}
+
+// waitForBeacon waits until the device with the given uuid sends an unprovisioned beacon
+// Beacons are still passed to the onUnprovisionedBeacon handler while waiting
+func (controller *Controller) waitForBeacon(ctx context.Context, uuid []byte) error {
+	err := controller.checkReader()
+	if err != nil {
+		return err
+	}
+	w := controller.pending.addStream(waitKey{op: OpUnprovisionedBeacon})
+	defer controller.pending.remove(w)
+	for {
+		select {
+		case beacon := <-w.frame:
+			if bytes.Equal(beacon[1:17], uuid) {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-controller.closing.Done():
+			return ErrClosed
+		}
+	}
+}
+
+// configureAdded binds the app key to a newly added node and adds each of its elems to groupAddr
+func (controller *Controller) configureAdded(ctx context.Context, node NodeInfo, appIdx uint16, groupAddr uint16) error {
+	err := controller.ConfigureNodeWait(ctx, node.Addr, appIdx)
+	if err != nil {
+		return err
+	}
+	elements := uint16(node.Elements)
+	if elements == 0 {
+		elements = 1
+	}
+	for i := uint16(0); i < elements; i++ {
+		err = controller.configureElemWait(ctx, groupAddr, node.Addr, node.Addr+i, appIdx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ConfigStatus is the status code a node replies with to a config message
+// Statuses other than ConfigSuccess are returned as errors
+type ConfigStatus byte
+
+// Config status codes from the mesh spec
+const (
+	ConfigSuccess               ConfigStatus = 0x00
+	ConfigInvalidAddress        ConfigStatus = 0x01
+	ConfigInvalidModel          ConfigStatus = 0x02
+	ConfigInvalidAppKeyIndex    ConfigStatus = 0x03
+	ConfigInvalidNetKeyIndex    ConfigStatus = 0x04
+	ConfigInsufficientResources ConfigStatus = 0x05
+	ConfigKeyIndexAlreadyStored ConfigStatus = 0x06
+	ConfigInvalidPublishParams  ConfigStatus = 0x07
+	ConfigNotASubscribeModel    ConfigStatus = 0x08
+	ConfigStorageFailure        ConfigStatus = 0x09
+	ConfigFeatureNotSupported   ConfigStatus = 0x0A
+	ConfigCannotUpdate          ConfigStatus = 0x0B
+	ConfigCannotRemove          ConfigStatus = 0x0C
+	ConfigCannotBind            ConfigStatus = 0x0D
+	ConfigTemporarilyUnable     ConfigStatus = 0x0E
+	ConfigCannotSet             ConfigStatus = 0x0F
+	ConfigUnspecifiedError      ConfigStatus = 0x10
+	ConfigInvalidBinding        ConfigStatus = 0x11
+)
+
+// configStatusText holds the message for each config status
+var configStatusText = map[ConfigStatus]string{
+	ConfigSuccess:               "Success",
+	ConfigInvalidAddress:        "Invalid address",
+	ConfigInvalidModel:          "Invalid model",
+	ConfigInvalidAppKeyIndex:    "Invalid app key index",
+	ConfigInvalidNetKeyIndex:    "Invalid net key index",
+	ConfigInsufficientResources: "Insufficient resources",
+	ConfigKeyIndexAlreadyStored: "Key index already stored",
+	ConfigInvalidPublishParams:  "Invalid publish parameters",
+	ConfigNotASubscribeModel:    "Not a subscribe model",
+	ConfigStorageFailure:        "Storage failure",
+	ConfigFeatureNotSupported:   "Feature not supported",
+	ConfigCannotUpdate:          "Cannot update",
+	ConfigCannotRemove:          "Cannot remove",
+	ConfigCannotBind:            "Cannot bind",
+	ConfigTemporarilyUnable:     "Temporarily unable to change state",
+	ConfigCannotSet:             "Cannot set",
+	ConfigUnspecifiedError:      "Unspecified error",
+	ConfigInvalidBinding:        "Invalid binding",
+}
+
+// Error returns the spec name of the status
+func (status ConfigStatus) Error() string {
+	text, ok := configStatusText[status]
+	if !ok {
+		return fmt.Sprintf("Unknown config status 0x%02X", byte(status))
+	}
+	return text
+}
+
+// configError returns nil for ConfigSuccess and the status as an error otherwise
+func configError(status byte) error {
+	if ConfigStatus(status) == ConfigSuccess {
+		return nil
+	}
+	return ConfigStatus(status)
+}
+
+// NodeIdentity is the state of a node advertising its identity on a subnet
+type NodeIdentity byte
+
+// Node identity states from the mesh spec
+const (
+	NodeIdentityStopped      NodeIdentity = 0x00
+	NodeIdentityRunning      NodeIdentity = 0x01
+	NodeIdentityNotSupported NodeIdentity = 0x02
+)
+
+// FeatureState is the state of a relay, proxy, friend or low power feature of a node
+type FeatureState byte
+
+// Feature states from the mesh spec
+const (
+	FeatureDisabled     FeatureState = 0x00
+	FeatureEnabled      FeatureState = 0x01
+	FeatureNotSupported FeatureState = 0x02
+)
+
+// Features holds the feature states of a node and how it retransmits messages
+type Features struct {
+	Relay                     FeatureState
+	RelayRetransmitCount      uint8
+	RelayRetransmitIntervalMs uint16
+	Proxy                     FeatureState
+	Friend                    FeatureState
+	LowPower                  FeatureState
+	NetworkTransmitCount      uint8
+	NetworkTransmitIntervalMs uint16
+}
+
+// GetNodeFeatures reads the feature states of the node with the given addr over the subnet at the given index
+// Transmit counts are the total number of transmissions as with SetNetworkTransmit
+func (controller *Controller) GetNodeFeatures(ctx context.Context, addr uint16, netIdx uint16) (Features, error) {
+	parms := []byte{OpGetNodeFeatures}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(netIdx)...)
+	reply, err := controller.request(ctx, parms, OpNodeFeatures, addr)
+	if err != nil {
+		return Features{}, err
+	}
+	// Reply is addr, relay, relay retransmit, proxy, friend, low power then network transmit
+	features := Features{
+		Relay:    FeatureState(reply[3]),
+		Proxy:    FeatureState(reply[5]),
+		Friend:   FeatureState(reply[6]),
+		LowPower: FeatureState(reply[7]),
+	}
+	features.RelayRetransmitCount, features.RelayRetransmitIntervalMs = decodeTransmit(reply[4])
+	features.NetworkTransmitCount, features.NetworkTransmitIntervalMs = decodeTransmit(reply[8])
+	return features, nil
+}
+
+// SetNodeDefaultTTL sets the TTL the node with the given addr uses for messages it sends such as publications
+// The mesh spec prohibits a ttl of 1, a ttl of 0 keeps messages to a single hop as they are never relayed
+func (controller *Controller) SetNodeDefaultTTL(addr uint16, netIdx uint16, ttl uint8) error {
+	if ttl == 1 || ttl > 0x7F {
+		return errors.New("Invalid ttl")
+	}
+	parms := []byte{OpSetNodeDefaultTTL}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(netIdx)...)
+	parms = append(parms, ttl)
+	return controller.WriteData(parms)
+}
+
+// SetNodeIdentity starts or stops the node with the given addr advertising its identity on the subnet at the given index
+// A node advertising its identity can be found and connected to by proxy clients
+func (controller *Controller) SetNodeIdentity(addr uint16, netIdx uint16, enabled bool) error {
+	parms := []byte{OpSetNodeIdentity}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(netIdx)...)
+	if enabled {
+		parms = append(parms, byte(NodeIdentityRunning))
+	} else {
+		parms = append(parms, byte(NodeIdentityStopped))
+	}
+	return controller.WriteData(parms)
+}
+
+// GetNodeIdentity reads the identity advertising state of the node with the given addr on the subnet at the given index
+func (controller *Controller) GetNodeIdentity(ctx context.Context, addr uint16, netIdx uint16) (NodeIdentity, error) {
+	parms := []byte{OpGetNodeIdentity}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(netIdx)...)
+	reply, err := controller.request(ctx, parms, OpNodeIdentityStatus, addr)
+	if err != nil {
+		return 0, err
+	}
+	// Reply is addr, status, net index then identity
+	err = configError(reply[3])
+	if err != nil {
+		return 0, err
+	}
+	return NodeIdentity(reply[6]), nil
+}
+
+// GetSubscriptions reads the group addrs a model of the elem with the given addr is subscribed to
+func (controller *Controller) GetSubscriptions(ctx context.Context, elemAddr uint16, modelID uint16) ([]uint16, error) {
+	parms := []byte{OpGetSubscriptions}
+	parms = append(parms, toByteSlice(elemAddr)...)
+	parms = append(parms, toByteSlice(modelID)...)
+	reply, err := controller.request(ctx, parms, OpSubscriptionList, elemAddr)
+	if err != nil {
+		return nil, err
+	}
+	// Reply is addr, status, model id then the list of addrs
+	err = configError(reply[3])
+	if err != nil {
+		return nil, err
+	}
+	return fromByteSlices(reply[6:]), nil
+}
+
+// IsKeyBound reports if the app key at appIdx is bound to a SIG model of the elem with the given addr
+// Messages sent with a key that is not bound to the model are silently dropped by the node
+// Use IsVendorKeyBound for vendor models
+func (controller *Controller) IsKeyBound(ctx context.Context, elemAddr uint16, modelID uint16, appIdx uint16) (bool, error) {
+	parms := []byte{OpGetModelKeys}
+	parms = append(parms, toByteSlice(elemAddr)...)
+	parms = append(parms, toByteSlice(modelID)...)
+	reply, err := controller.request(ctx, parms, OpModelKeyList, elemAddr)
+	if err != nil {
+		return false, err
+	}
+	// Reply is addr, status, model id then the list of bound app key indexes
+	if fromByteSlice(reply[4:6]) != modelID {
+		return false, errors.New("Model key list for wrong model")
+	}
+	return keyListed(reply[3], reply[6:], appIdx)
+}
+
+// IsVendorKeyBound reports if the app key at appIdx is bound to the vendor model of the elem with the given addr
+func (controller *Controller) IsVendorKeyBound(ctx context.Context, elemAddr uint16, companyID uint16, modelID uint16, appIdx uint16) (bool, error) {
+	parms := []byte{OpGetVendorModelKeys}
+	parms = append(parms, toByteSlice(elemAddr)...)
+	parms = append(parms, toByteSlice(companyID)...)
+	parms = append(parms, toByteSlice(modelID)...)
+	reply, err := controller.request(ctx, parms, OpVendorModelKeyList, elemAddr)
+	if err != nil {
+		return false, err
+	}
+	// Reply is addr, status, company id, model id then the list of bound app key indexes
+	if fromByteSlice(reply[4:6]) != companyID || fromByteSlice(reply[6:8]) != modelID {
+		return false, errors.New("Model key list for wrong model")
+	}
+	return keyListed(reply[3], reply[8:], appIdx)
+}
+
+// keyListed reports if appIdx is in a model key list with the given config status
+func keyListed(status byte, list []byte, appIdx uint16) (bool, error) {
+	err := configError(status)
+	if err != nil {
+		return false, err
+	}
+	for _, idx := range fromByteSlices(list) {
+		if idx == appIdx {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetNetworkTransmit sets how many times the Mesh Controller transmits each network message and the interval between them
+// count is the total number of transmissions from 1 to 8 and intervalMs is a multiple of 10 from 10 to 320
+func (controller *Controller) SetNetworkTransmit(count uint8, intervalMs uint16) error {
+	transmit, err := encodeTransmit(count, intervalMs)
+	if err != nil {
+		return err
+	}
+	return controller.WriteData([]byte{OpSetNetworkTransmit, transmit})
+}
+
+// GetNetworkTransmit reads the number of transmissions and the interval in ms set with SetNetworkTransmit
+// Read must be running for the reply to be received
+func (controller *Controller) GetNetworkTransmit() (uint8, uint16, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+	reply, err := controller.request(ctx, []byte{OpGetNetworkTransmit}, OpNetworkTransmit, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, intervalMs := decodeTransmit(reply[1])
+	return count, intervalMs, nil
+}
+
+// encodeTransmit packs a transmission count and interval into the mesh spec's 3 bit count and 5 bit interval steps
+func encodeTransmit(count uint8, intervalMs uint16) (byte, error) {
+	if count < 1 || count > 8 {
+		return 0, errors.New("Invalid transmit count")
+	}
+	if intervalMs < 10 || intervalMs > 320 || intervalMs%10 != 0 {
+		return 0, errors.New("Invalid transmit interval")
+	}
+	return byte(intervalMs/10-1)<<3 | (count - 1), nil
+}
+
+// decodeTransmit unpacks a transmission count and interval in ms
+func decodeTransmit(transmit byte) (uint8, uint16) {
+	return transmit&0x07 + 1, (uint16(transmit>>3) + 1) * 10
+}
@@ -0,0 +1,69 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// databaseTimeout is how long the controller has to write an imported database to flash
+const databaseTimeout = 10 * time.Second
+
+// ExportDatabase reads the network state the Mesh Controller keeps in flash
+// This holds the network and app keys, the node list and addrs so it must be stored securely
+// The controller sends the state as OpDatabaseChunk frames using the same header as WriteData chunks
+// Read must be running for the reply to be received
+func (controller *Controller) ExportDatabase() ([]byte, error) {
+	err := controller.checkReader()
+	if err != nil {
+		return nil, err
+	}
+	w := controller.pending.addStream(waitKey{op: OpDatabaseChunk})
+	defer controller.pending.remove(w)
+	err = controller.WriteData([]byte{OpExportDatabase})
+	if err != nil {
+		return nil, err
+	}
+	var database []byte
+	timer := time.NewTimer(responseTimeout)
+	defer timer.Stop()
+	for index := 0; ; index++ {
+		var chunk []byte
+		select {
+		case chunk = <-w.frame:
+		case <-timer.C:
+			return nil, errors.New("Timed out exporting database")
+		}
+		// Chunks must arrive in order
+		if int(chunk[1]&0x7F) != index%0x80 {
+			return nil, errors.New("Database chunk missing")
+		}
+		database = append(database, chunk[2:]...)
+		if chunk[1]&0x80 != 0 {
+			return database, nil
+		}
+		timer.Reset(responseTimeout)
+	}
+}
+
+// ImportDatabase replaces the network state of the Mesh Controller with one from ExportDatabase
+// The known nodes are forgotten once the controller accepts it so ListNodes should be called after importing
+// A rejected database leaves the known nodes as they were
+// WriteData rejects a database too large to send in 128 chunks
+// Read must be running for the reply to be received
+func (controller *Controller) ImportDatabase(database []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+	defer cancel()
+	parms := []byte{OpImportDatabase}
+	parms = append(parms, database...)
+	reply, err := controller.request(ctx, parms, OpImportStatus, 0)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("Controller rejected database")
+	}
+	// Only forget the nodes once the controller holds the new ones
+	controller.nodes.clear()
+	return nil
+}
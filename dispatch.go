@@ -0,0 +1,214 @@
+package mesh
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// handlerTable maps op codes to the funcs called with frames that have them
+// raw is called with every frame before it is checked or routed
+type handlerTable struct {
+	mu       sync.RWMutex
+	handlers map[byte]func(frame []byte)
+	raw      func(frame []byte)
+}
+
+func newHandlerTable() *handlerTable {
+	return &handlerTable{handlers: make(map[byte]func(frame []byte))}
+}
+
+// set replaces the handler for op, a nil fn removes it
+func (table *handlerTable) set(op byte, fn func(frame []byte)) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if fn == nil {
+		delete(table.handlers, op)
+		return
+	}
+	table.handlers[op] = fn
+}
+
+// get returns the handler for op or nil
+func (table *handlerTable) get(op byte) func(frame []byte) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.handlers[op]
+}
+
+// SetMonitorMode makes the Mesh Controller forward every mesh message it sees as an OpMonitorFrame
+// Each monitor frame is OpMonitorFrame, the source addr, the destination addr then the decrypted access payload
+func (controller *Controller) SetMonitorMode(enabled bool) error {
+	if enabled {
+		return controller.WriteData([]byte{OpSetMonitorMode, 0x01})
+	}
+	return controller.WriteData([]byte{OpSetMonitorMode, 0x00})
+}
+
+// OnRawFrame sets a func called with every frame Read receives before it is checked, filtered or handled
+// nil removes it
+func (controller *Controller) OnRawFrame(fn func(frame []byte)) {
+	controller.handlers.mu.Lock()
+	controller.handlers.raw = fn
+	controller.handlers.mu.Unlock()
+}
+
+// sourceFilter holds the node addrs whose frames are handled, a nil set allows every node
+type sourceFilter struct {
+	mu    sync.RWMutex
+	addrs map[uint16]bool
+}
+
+// allows reports if a frame from addr should be handled
+func (filter *sourceFilter) allows(addr uint16) bool {
+	filter.mu.RLock()
+	defer filter.mu.RUnlock()
+	return filter.addrs == nil || filter.addrs[addr]
+}
+
+// SetSourceFilter drops frames from nodes other than the given addrs before they reach the handlers
+// Frames from the controller itself and replies to requests are never dropped
+// Calling it with no addrs removes the filter
+func (controller *Controller) SetSourceFilter(addrs ...uint16) {
+	var set map[uint16]bool
+	if len(addrs) > 0 {
+		set = make(map[uint16]bool, len(addrs))
+		for _, addr := range addrs {
+			set[addr] = true
+		}
+	}
+	controller.filter.mu.Lock()
+	controller.filter.addrs = set
+	controller.filter.mu.Unlock()
+}
+
+// stateCache holds the last state seen from each addr for CoalesceStates
+type stateCache struct {
+	mu     sync.Mutex
+	states map[uint16]byte
+}
+
+// repeat stores the state of an OpState frame and reports if it matches the last one from its addr
+func (cache *stateCache) repeat(frame []byte) bool {
+	addr := fromByteSlice(frame[1:3])
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	last, ok := cache.states[addr]
+	cache.states[addr] = frame[3]
+	return ok && last == frame[3]
+}
+
+// clear forgets every cached state
+func (cache *stateCache) clear() {
+	cache.mu.Lock()
+	cache.states = make(map[uint16]byte)
+	cache.mu.Unlock()
+}
+
+// frameLength is the expected length of frames with an op code
+// Fixed length frames must be exactly size long and variable ones at least size
+type frameLength struct {
+	size     int
+	variable bool
+}
+
+// frameLengths holds the length of each frame the controller sends
+var frameLengths = map[byte]frameLength{
+	OpSetupStatus:         {size: 1},
+	OpAddKeyStatus:        {size: 3},
+	OpUnprovisionedBeacon: {size: 17},
+	OpNodeAdded:           {size: 3, variable: true},
+	OpState:               {size: 4},
+	OpEvent:               {size: 3, variable: true},
+	OpDefaultTTLStatus:    {size: 2},
+	OpNodeIdentityStatus:  {size: 7},
+	OpSubscriptionList:    {size: 6, variable: true},
+	OpBusy:                {size: 1},
+	OpReady:               {size: 1},
+	OpNetworkTransmit:     {size: 2},
+	OpNodeInfo:            {size: 5, variable: true},
+	OpFaultStatus:         {size: 6, variable: true},
+	OpHSLStatus:           {size: 9},
+	OpMonitorFrame:        {size: 5, variable: true},
+	OpTimeStatus:          {size: 13},
+	OpScheduleStatus:      {size: 13},
+	OpSceneStatus:         {size: 6, variable: true},
+	OpDatabaseChunk:       {size: 2, variable: true},
+	OpImportStatus:        {size: 2},
+	OpComposition:         {size: 1, variable: true},
+	OpNodeFeatures:        {size: 9},
+	OpKeyRefreshStatus:    {size: 3},
+	OpCapabilities:        {size: 12},
+	OpConfigureNodeStatus: {size: 4},
+	OpSequenceStatus:      {size: 4},
+	OpAppKey:              {size: 20},
+	OpModelKeyList:        {size: 6, variable: true},
+	OpVendorModelKeyList:  {size: 8, variable: true},
+	OpBatteryStatus:       {size: 11},
+	OpConfigureElemStatus: {size: 8},
+}
+
+// validFrame reports if frame has the length expected for its op code, unknown op codes are not checked
+func validFrame(frame []byte) bool {
+	length, ok := frameLengths[frame[0]]
+	if !ok {
+		return true
+	}
+	if length.variable {
+		return len(frame) >= length.size
+	}
+	return len(frame) == length.size
+}
+
+// InvalidFrames returns how many frames have been dropped for having the wrong length for their op code
+func (controller *Controller) InvalidFrames() uint64 {
+	return atomic.LoadUint64(controller.invalidFrames)
+}
+
+// dispatch routes a frame read from the Mesh Controller at the received time
+func (controller *Controller) dispatch(frame []byte, received time.Time) {
+	controller.handlers.mu.RLock()
+	raw := controller.handlers.raw
+	controller.handlers.mu.RUnlock()
+	if raw != nil {
+		raw(frame)
+	}
+	// Drop frames that could be corrupt and count them
+	if !validFrame(frame) {
+		atomic.AddUint64(controller.invalidFrames, 1)
+		return
+	}
+	controller.eventLog.record(frame, received)
+	// Repeated states are only dropped once any waiting request has had them
+	repeat := controller.CoalesceStates && frame[0] == OpState && controller.states.repeat(frame)
+	if !repeat {
+		controller.events.publish(frame, received)
+	}
+	// Keep track of nodes and flow control whichever handler is set
+	switch frame[0] {
+	case OpNodeAdded:
+		controller.nodes.add(fromByteSlice(frame[1:3]), addedElements(frame))
+		controller.busy.clear()
+	case OpBusy:
+		controller.busy.set()
+	case OpReady:
+		controller.busy.clear()
+	case OpCapabilities:
+		controller.negotiate(frame)
+		return
+	}
+	// Hand replies to any waiting request and still pass them to the handlers
+	controller.pending.deliver(frame)
+	if repeat {
+		return
+	}
+	// Drop frames from nodes the app is not interested in
+	addr := sourceAddr(frame)
+	if addr != 0 && !controller.filter.allows(addr) {
+		return
+	}
+	fn := controller.handlers.get(frame[0])
+	if fn != nil {
+		controller.pool.run(fn, frame)
+	}
+}
@@ -0,0 +1,248 @@
+package mesh
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestController makes a Controller writing to a fake endpoint as if Read were running
+// reply is called with each written packet and the frame it returns is dispatched as the reply
+func newTestController(reply func(packet []byte) []byte) (Controller, *fakeEndpoint) {
+	controller := newController(DefaultOpenConfig(), nil)
+	endpoint := &fakeEndpoint{}
+	controller.epOut = endpoint
+	controller.outPacketSize = 64
+	atomic.AddInt32(controller.reading, 1)
+	if reply != nil {
+		endpoint.onWrite = func(packet []byte) {
+			frame := reply(packet)
+			if frame != nil {
+				controller.dispatch(frame, time.Now())
+			}
+		}
+	}
+	return controller, endpoint
+}
+
+func TestWaitedReplyPassedToHandler(t *testing.T) {
+	controller := newController(DefaultOpenConfig(), nil)
+	var handled []byte
+	controller.SetHandler(OpState, func(frame []byte) {
+		handled = frame
+	})
+	// A TestNode style request waits for the state of the node
+	w := controller.pending.add(waitKey{op: OpState, addr: 0x0005})
+	defer controller.pending.remove(w)
+	frame := []byte{OpState, 0x05, 0x00, 0x01}
+	controller.dispatch(frame, time.Now())
+	select {
+	case <-w.frame:
+	default:
+		t.Fatal("waiter did not receive the reply")
+	}
+	if handled == nil {
+		t.Fatal("handler did not receive the reply")
+	}
+}
+
+func TestBusyFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		data   []byte
+		err    error
+	}{
+		{"busy", [][]byte{{OpBusy}}, []byte{OpSendMessage, 0x01, 0x05, 0x00, 0x00, 0x00}, ErrControllerBusy},
+		{"ready", [][]byte{{OpBusy}, {OpReady}}, []byte{OpSendMessage, 0x01, 0x05, 0x00, 0x00, 0x00}, nil},
+		{"node added", [][]byte{{OpBusy}, {OpNodeAdded, 0x05, 0x00, 0x01}}, []byte{OpSendMessage, 0x01, 0x05, 0x00, 0x00, 0x00}, nil},
+		{"reset while busy", [][]byte{{OpBusy}}, []byte{OpReset}, nil},
+	}
+	for _, test := range tests {
+		controller, _ := newTestController(nil)
+		for _, frame := range test.frames {
+			controller.dispatch(frame, time.Now())
+		}
+		err := controller.WriteData(test.data)
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestKeyRefreshPhases(t *testing.T) {
+	// The controller moves to the phase after each step
+	phases := map[byte]byte{
+		OpStartKeyRefresh:    keyRefreshDistribute,
+		OpDistributeKeys:     keyRefreshSwitch,
+		OpCompleteKeyRefresh: keyRefreshNormal,
+	}
+	steps := map[string]func(controller Controller) error{
+		"start":      func(controller Controller) error { return controller.StartKeyRefresh(0) },
+		"distribute": func(controller Controller) error { return controller.DistributeNewKeys() },
+		"complete":   func(controller Controller) error { return controller.CompleteKeyRefresh() },
+	}
+	tests := []struct {
+		name  string
+		steps []string
+		fail  bool
+	}{
+		{"in order", []string{"start", "distribute", "complete"}, false},
+		{"distribute first", []string{"distribute"}, true},
+		{"complete before distribute", []string{"start", "complete"}, true},
+		{"start twice", []string{"start", "start"}, true},
+		{"distribute twice", []string{"start", "distribute", "distribute"}, true},
+		{"start after complete", []string{"start", "distribute", "complete", "start"}, false},
+	}
+	for _, test := range tests {
+		controller, _ := newTestController(func(packet []byte) []byte {
+			return []byte{OpKeyRefreshStatus, phases[packet[0]], 0x00}
+		})
+		var err error
+		for _, step := range test.steps {
+			err = steps[step](controller)
+			if err != nil {
+				break
+			}
+		}
+		if (err != nil) != test.fail {
+			t.Errorf("%s: got %v, want failure %v", test.name, err, test.fail)
+		}
+	}
+}
+
+func TestKeyRefreshRejectedPhase(t *testing.T) {
+	// The controller stays in the normal phase
+	controller, _ := newTestController(func(packet []byte) []byte {
+		return []byte{OpKeyRefreshStatus, keyRefreshNormal, 0x00}
+	})
+	if controller.StartKeyRefresh(0) == nil {
+		t.Fatal("start succeeded without the controller changing phase")
+	}
+	if err := controller.DistributeNewKeys(); err != ErrNoKeyRefresh {
+		t.Fatalf("got %v, want ErrNoKeyRefresh", err)
+	}
+}
+
+func TestGetAppKeyIndex(t *testing.T) {
+	key := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F}
+	tests := []struct {
+		name     string
+		replyIdx uint16
+		fail     bool
+	}{
+		{"same index", 0x0002, false},
+		{"other index", 0x0003, true},
+	}
+	for _, test := range tests {
+		controller, _ := newTestController(func(packet []byte) []byte {
+			reply := []byte{OpAppKey}
+			reply = append(reply, toByteSlice(test.replyIdx)...)
+			reply = append(reply, 0x00)
+			return append(reply, key...)
+		})
+		got, err := controller.GetAppKey(0x0002)
+		if (err != nil) != test.fail {
+			t.Errorf("%s: got %v, want failure %v", test.name, err, test.fail)
+		}
+		if err == nil && string(got) != string(key) {
+			t.Errorf("%s: got key %v", test.name, got)
+		}
+	}
+}
+
+func TestResetAbortsProvisioning(t *testing.T) {
+	controller, endpoint := newTestController(nil)
+	controller.busy.set()
+	err := controller.Reset()
+	if err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	if len(endpoint.packets) != 2 || endpoint.packets[0][0] != OpAbortProvisioning || endpoint.packets[1][0] != OpReset {
+		t.Fatalf("got packets %v", endpoint.packets)
+	}
+	if controller.busy.isBusy() {
+		t.Fatal("controller still busy after abort")
+	}
+}
+
+func TestFactoryResetClearsState(t *testing.T) {
+	controller, _ := newTestController(nil)
+	controller.busy.set()
+	controller.keyRefresh.active = true
+	controller.keyRefresh.phase = keyRefreshSwitch
+	controller.states.states[0x0005] = 0x01
+	// Give up before the controller would be reopened
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := controller.FactoryReset(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if controller.busy.isBusy() || controller.keyRefresh.active || len(controller.states.states) != 0 {
+		t.Fatal("state from the old network was kept")
+	}
+	// Writes fail until the controller is reopened
+	if err := controller.WriteData([]byte{OpReboot}); err != ErrControllerNotFound {
+		t.Fatalf("got %v, want ErrControllerNotFound", err)
+	}
+}
+
+func TestFactoryResetStopsOnClose(t *testing.T) {
+	var controller Controller
+	// Close while the controller is rebooting
+	controller, _ = newTestController(func(packet []byte) []byte {
+		if packet[0] == OpReboot {
+			controller.stop()
+		}
+		return nil
+	})
+	err := controller.FactoryReset(context.Background())
+	if err != ErrClosed {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+}
+
+func TestListNodesFrames(t *testing.T) {
+	// Frames are op, index, total, addr, elem count then app key indexes
+	first := []byte{OpNodeInfo, 0x00, 0x00, 0x02, 0x00, 0x05, 0x00, 0x02, 0x00, 0x00}
+	second := []byte{OpNodeInfo, 0x01, 0x00, 0x02, 0x00, 0x07, 0x00, 0x01}
+	tests := []struct {
+		name   string
+		frames [][]byte
+		addrs  []uint16
+		fail   bool
+	}{
+		{"no nodes", [][]byte{{OpNodeInfo, 0x00, 0x00, 0x00, 0x00}}, nil, false},
+		{"two nodes", [][]byte{first, second}, []uint16{0x0005, 0x0007}, false},
+		{"out of order", [][]byte{second, first}, nil, true},
+	}
+	for _, test := range tests {
+		controller, endpoint := newTestController(nil)
+		frames := test.frames
+		endpoint.onWrite = func(packet []byte) {
+			for _, frame := range frames {
+				controller.dispatch(frame, time.Now())
+			}
+		}
+		nodes, err := controller.ListNodes()
+		if (err != nil) != test.fail {
+			t.Errorf("%s: got %v, want failure %v", test.name, err, test.fail)
+			continue
+		}
+		if len(nodes) != len(test.addrs) {
+			t.Errorf("%s: got nodes %v", test.name, nodes)
+			continue
+		}
+		for i, node := range nodes {
+			if node.Addr != test.addrs[i] {
+				t.Errorf("%s: got nodes %v", test.name, nodes)
+			}
+		}
+		// Listed nodes become known to strict addressing
+		if !test.fail && len(test.addrs) > 0 && !controller.nodes.knows(test.addrs[0]) {
+			t.Errorf("%s: listed node is not known", test.name)
+		}
+	}
+}
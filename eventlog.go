@@ -0,0 +1,77 @@
+package mesh
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a frame received from the Mesh Controller as kept by the event log and yielded by AllEvents
+type Event struct {
+	// Time is when the frame was read from usb, before any handler ran
+	Time time.Time
+	Op   byte
+	// Addr is the node the frame came from, zero for frames from the controller itself
+	Addr  uint16
+	Frame []byte
+}
+
+// LoggedEvent is the type EventLog returned before Event was shared with AllEvents
+type LoggedEvent = Event
+
+// newEvent copies a frame read at the received time into an Event
+func newEvent(frame []byte, received time.Time) Event {
+	return Event{
+		Time:  received,
+		Op:    frame[0],
+		Addr:  sourceAddr(frame),
+		Frame: append([]byte(nil), frame...),
+	}
+}
+
+// eventLog is a ring buffer of the most recently received frames
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// EnableEventLog keeps the last size frames received by Read, a size of zero or less disables the log
+// Enabling the log again clears it
+func (controller *Controller) EnableEventLog(size int) {
+	controller.eventLog.mu.Lock()
+	defer controller.eventLog.mu.Unlock()
+	controller.eventLog.events = nil
+	if size > 0 {
+		controller.eventLog.events = make([]Event, size)
+	}
+	controller.eventLog.next = 0
+	controller.eventLog.full = false
+}
+
+// EventLog returns the logged frames from oldest to newest
+func (controller *Controller) EventLog() []Event {
+	log := controller.eventLog
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if !log.full {
+		return append([]Event(nil), log.events[:log.next]...)
+	}
+	events := append([]Event(nil), log.events[log.next:]...)
+	return append(events, log.events[:log.next]...)
+}
+
+// record adds a frame read at the received time to the log if it is enabled
+func (log *eventLog) record(frame []byte, received time.Time) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if len(log.events) == 0 {
+		return
+	}
+	log.events[log.next] = newEvent(frame, received)
+	log.next++
+	if log.next == len(log.events) {
+		log.next = 0
+		log.full = true
+	}
+}
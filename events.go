@@ -0,0 +1,74 @@
+package mesh
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"time"
+)
+
+// eventSubscribers holds the chans of every running AllEvents loop
+type eventSubscribers struct {
+	mu   sync.Mutex
+	subs map[chan Event]bool
+}
+
+func newEventSubscribers() *eventSubscribers {
+	return &eventSubscribers{subs: make(map[chan Event]bool)}
+}
+
+// subscribe returns a chan that gets every published event until it is unsubscribed
+func (events *eventSubscribers) subscribe() chan Event {
+	ch := make(chan Event, streamBuffer)
+	events.mu.Lock()
+	events.subs[ch] = true
+	events.mu.Unlock()
+	return ch
+}
+
+// unsubscribe stops publishing to ch
+func (events *eventSubscribers) unsubscribe(ch chan Event) {
+	events.mu.Lock()
+	delete(events.subs, ch)
+	events.mu.Unlock()
+}
+
+// publish sends a frame read at the received time to every subscriber
+// Events are dropped for subscribers that are behind
+func (events *eventSubscribers) publish(frame []byte, received time.Time) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if len(events.subs) == 0 {
+		return
+	}
+	event := newEvent(frame, received)
+	for ch := range events.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// AllEvents returns an iterator over every valid frame Read receives, including replies to requests
+// Frames are yielded whichever handlers or source filter are set
+// The loop ends when ctx is done or the controller is closed
+// Events arriving while the loop body is busy are buffered, once the buffer is full they are dropped
+func (controller *Controller) AllEvents(ctx context.Context) iter.Seq[Event] {
+	return func(yield func(Event) bool) {
+		events := controller.events.subscribe()
+		defer controller.events.unsubscribe(events)
+		for {
+			select {
+			case event := <-events:
+				if !yield(event) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-controller.closing.Done():
+				return
+			}
+		}
+	}
+}
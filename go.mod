@@ -1,5 +1,5 @@
 module github.com/AJGherardi/GoMeshController
 
-go 1.14
+go 1.23
 
-require github.com/google/gousb v1.1.1
+require github.com/google/gousb v1.1.3
@@ -0,0 +1,69 @@
+package mesh
+
+import (
+	"context"
+	"time"
+)
+
+// Fault is a fault code reported by the health server of a node
+type Fault byte
+
+// Fault codes from the mesh spec
+const (
+	FaultNone                     Fault = 0x00
+	FaultBatteryLowWarning        Fault = 0x01
+	FaultBatteryLowError          Fault = 0x02
+	FaultSupplyVoltageLowWarning  Fault = 0x03
+	FaultSupplyVoltageLowError    Fault = 0x04
+	FaultSupplyVoltageHighWarning Fault = 0x05
+	FaultSupplyVoltageHighError   Fault = 0x06
+	FaultPowerInterruptedWarning  Fault = 0x07
+	FaultPowerInterruptedError    Fault = 0x08
+	FaultNoLoadWarning            Fault = 0x09
+	FaultNoLoadError              Fault = 0x0A
+	FaultOverloadWarning          Fault = 0x0B
+	FaultOverloadError            Fault = 0x0C
+	FaultOverheatWarning          Fault = 0x0D
+	FaultOverheatError            Fault = 0x0E
+)
+
+// GetFaults reads the registered faults of the node with the given addr using the app key at the given index
+func (controller *Controller) GetFaults(ctx context.Context, addr uint16, appIdx uint16) ([]Fault, error) {
+	parms := []byte{OpGetFaults}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpFaultStatus, addr)
+	if err != nil {
+		return nil, err
+	}
+	// Reply is addr, test id, company id then the fault codes
+	faults := make([]Fault, 0, len(reply)-6)
+	for _, code := range reply[6:] {
+		faults = append(faults, Fault(code))
+	}
+	return faults, nil
+}
+
+// ClearFaults clears the registered faults of the node with the given addr using the app key at the given index
+func (controller *Controller) ClearFaults(addr uint16, appIdx uint16) error {
+	parms := []byte{OpClearFaults}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
+
+// TestNode checks the node with the given addr can receive and reply by sending it a generic on off get
+// It returns the round trip time in milliseconds from writing the get to reading the OpState reply
+// The reply is still passed to the onState handler
+// Read must be running for the reply to be received
+func (controller *Controller) TestNode(ctx context.Context, addr uint16, appIdx uint16) (int, error) {
+	parms := []byte{OpGetState}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	sent := time.Now()
+	_, err := controller.request(ctx, parms, OpState, addr)
+	if err != nil {
+		return 0, err
+	}
+	return int(time.Since(sent).Milliseconds()), nil
+}
@@ -0,0 +1,37 @@
+package mesh
+
+import "context"
+
+// SetHSL sends a bt mesh light hsl message using the app key at the given index to the given addr
+// Each call is a new transaction so a fast sweep of colors is applied in full by the node
+func (controller *Controller) SetHSL(addr uint16, appIdx uint16, hue uint16, saturation uint16, lightness uint16) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
+	// The light hsl model orders lightness before hue and saturation
+	parms := []byte{OpSendHSLMessage}
+	parms = append(parms, toByteSlice(lightness)...)
+	parms = append(parms, toByteSlice(hue)...)
+	parms = append(parms, toByteSlice(saturation)...)
+	parms = append(parms, controller.NewTID())
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
+
+// GetHSL reads the hue, saturation and lightness of the node with the given addr using the app key at the given index
+func (controller *Controller) GetHSL(ctx context.Context, addr uint16, appIdx uint16) (uint16, uint16, uint16, error) {
+	parms := []byte{OpGetHSL}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpHSLStatus, addr)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// Reply is addr, lightness, hue then saturation
+	lightness := fromByteSlice(reply[3:5])
+	hue := fromByteSlice(reply[5:7])
+	saturation := fromByteSlice(reply[7:9])
+	return hue, saturation, lightness, nil
+}
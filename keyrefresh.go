@@ -0,0 +1,115 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// keyRefreshTimeout is how long the controller has to update the keys of every node
+const keyRefreshTimeout = 30 * time.Second
+
+// Key refresh phases from the mesh spec
+const (
+	keyRefreshNormal     = 0x00
+	keyRefreshDistribute = 0x01
+	keyRefreshSwitch     = 0x02
+)
+
+// keyRefresh tracks the key refresh procedure in progress
+type keyRefresh struct {
+	mu     sync.Mutex
+	active bool
+	netIdx uint16
+	phase  uint8
+}
+
+// reset forgets any key refresh in progress
+func (refresh *keyRefresh) reset() {
+	refresh.mu.Lock()
+	refresh.active = false
+	refresh.netIdx = 0
+	refresh.phase = keyRefreshNormal
+	refresh.mu.Unlock()
+}
+
+// StartKeyRefresh makes the Mesh Controller generate a new net key and app keys for the subnet at netIdx
+// The old keys stay in use so the nodes do not need to be online yet
+// DistributeNewKeys and CompleteKeyRefresh must then be called in order to finish the refresh
+// Read must be running for the reply to be received
+func (controller *Controller) StartKeyRefresh(netIdx uint16) error {
+	refresh := controller.keyRefresh
+	refresh.mu.Lock()
+	defer refresh.mu.Unlock()
+	if refresh.active {
+		return errors.New("Key refresh already in progress")
+	}
+	err := controller.keyRefreshStep(OpStartKeyRefresh, netIdx, keyRefreshDistribute)
+	if err != nil {
+		return err
+	}
+	refresh.active = true
+	refresh.netIdx = netIdx
+	refresh.phase = keyRefreshDistribute
+	return nil
+}
+
+// DistributeNewKeys sends the new keys to every known node then switches the subnet to them
+// Nodes that miss the update can not talk to the network after the switch and must be provisioned again
+// Read must be running for the reply to be received
+func (controller *Controller) DistributeNewKeys() error {
+	refresh := controller.keyRefresh
+	refresh.mu.Lock()
+	defer refresh.mu.Unlock()
+	if !refresh.active {
+		return ErrNoKeyRefresh
+	}
+	if refresh.phase != keyRefreshDistribute {
+		return errors.New("New keys already distributed")
+	}
+	err := controller.keyRefreshStep(OpDistributeKeys, refresh.netIdx, keyRefreshSwitch)
+	if err != nil {
+		return err
+	}
+	refresh.phase = keyRefreshSwitch
+	return nil
+}
+
+// CompleteKeyRefresh revokes the old keys on every node and the Mesh Controller ending the key refresh
+// Read must be running for the reply to be received
+func (controller *Controller) CompleteKeyRefresh() error {
+	refresh := controller.keyRefresh
+	refresh.mu.Lock()
+	defer refresh.mu.Unlock()
+	if !refresh.active {
+		return ErrNoKeyRefresh
+	}
+	if refresh.phase != keyRefreshSwitch {
+		return errors.New("New keys must be distributed first")
+	}
+	err := controller.keyRefreshStep(OpCompleteKeyRefresh, refresh.netIdx, keyRefreshNormal)
+	if err != nil {
+		return err
+	}
+	refresh.active = false
+	refresh.phase = keyRefreshNormal
+	return nil
+}
+
+// keyRefreshStep sends a key refresh op for netIdx and waits for the controller to reach phase
+// The reply is OpKeyRefreshStatus, the phase the controller is in then a status byte
+func (controller *Controller) keyRefreshStep(op byte, netIdx uint16, phase uint8) error {
+	ctx, cancel := context.WithTimeout(context.Background(), keyRefreshTimeout)
+	defer cancel()
+	parms := []byte{op}
+	parms = append(parms, toByteSlice(netIdx)...)
+	reply, err := controller.request(ctx, parms, OpKeyRefreshStatus, 0)
+	if err != nil {
+		return err
+	}
+	if reply[2] != 0 || reply[1] != phase {
+		return errors.New("Controller rejected key refresh")
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package mesh
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/gousb"
+)
+
+// findLayout returns the usb layout to open the controller with
+// The preferred layout is used if the device has it, otherwise every config, interface and alt setting
+// is searched for one with a bulk in and a bulk out endpoint
+func findLayout(desc *gousb.DeviceDesc, preferred OpenConfig) (OpenConfig, error) {
+	if hasLayout(desc, preferred) {
+		return preferred, nil
+	}
+	var found []string
+	for _, cfg := range sortedConfigs(desc) {
+		for _, intf := range cfg.Interfaces {
+			for _, alt := range intf.AltSettings {
+				in, hasIn := bulkEndpoint(alt, gousb.EndpointDirectionIn)
+				out, hasOut := bulkEndpoint(alt, gousb.EndpointDirectionOut)
+				if hasIn && hasOut {
+					return OpenConfig{
+						Config:      cfg.Number,
+						Interface:   alt.Number,
+						AltSetting:  alt.Alternate,
+						InEndpoint:  in,
+						OutEndpoint: out,
+					}, nil
+				}
+				found = append(found, describeSetting(cfg.Number, alt))
+			}
+		}
+	}
+	if len(found) == 0 {
+		return OpenConfig{}, errors.New("No usb interfaces found on controller")
+	}
+	return OpenConfig{}, fmt.Errorf("No interface with bulk in and out endpoints found on controller, found %s", strings.Join(found, "; "))
+}
+
+// hasLayout reports if the device has the in and out endpoints of layout
+func hasLayout(desc *gousb.DeviceDesc, layout OpenConfig) bool {
+	cfg, ok := desc.Configs[layout.Config]
+	if !ok {
+		return false
+	}
+	for _, intf := range cfg.Interfaces {
+		if intf.Number != layout.Interface {
+			continue
+		}
+		for _, alt := range intf.AltSettings {
+			if alt.Alternate == layout.AltSetting {
+				return hasEndpoint(alt, layout.InEndpoint, gousb.EndpointDirectionIn) &&
+					hasEndpoint(alt, layout.OutEndpoint, gousb.EndpointDirectionOut)
+			}
+		}
+	}
+	return false
+}
+
+// hasEndpoint reports if alt has an endpoint with the given number and direction
+func hasEndpoint(alt gousb.InterfaceSetting, number int, direction gousb.EndpointDirection) bool {
+	for _, ep := range alt.Endpoints {
+		if ep.Number == number && ep.Direction == direction {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkEndpoint returns the lowest numbered bulk endpoint of alt in the given direction
+func bulkEndpoint(alt gousb.InterfaceSetting, direction gousb.EndpointDirection) (int, bool) {
+	number, ok := 0, false
+	for _, ep := range alt.Endpoints {
+		if ep.TransferType != gousb.TransferTypeBulk || ep.Direction != direction {
+			continue
+		}
+		if !ok || ep.Number < number {
+			number, ok = ep.Number, true
+		}
+	}
+	return number, ok
+}
+
+// sortedConfigs returns the configs of the device in order of their numbers
+func sortedConfigs(desc *gousb.DeviceDesc) []gousb.ConfigDesc {
+	var configs []gousb.ConfigDesc
+	for _, cfg := range desc.Configs {
+		configs = append(configs, cfg)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Number < configs[j].Number
+	})
+	return configs
+}
+
+// describeSetting lists the endpoints of an alt setting for errors
+func describeSetting(config int, alt gousb.InterfaceSetting) string {
+	var endpoints []string
+	for _, ep := range alt.Endpoints {
+		endpoints = append(endpoints, ep.String())
+	}
+	sort.Strings(endpoints)
+	return fmt.Sprintf("config %d interface %d alt %d with [%s]", config, alt.Number, alt.Alternate, strings.Join(endpoints, ", "))
+}
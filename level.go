@@ -0,0 +1,39 @@
+package mesh
+
+// SendLevelDelta sends a bt mesh generic level delta message using the app key at the given index to the given addr
+// Every delta of one gesture must use the same tid so the node applies them relative to the level the gesture started at
+func (controller *Controller) SendLevelDelta(addr uint16, appIdx uint16, delta int32, tid uint8) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
+	parms := []byte{OpSendLevelDelta, 0x00, 0x00, 0x00, 0x00}
+	byteOrder.PutUint32(parms[1:5], uint32(delta))
+	parms = append(parms, tid)
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
+
+// SendLevelMove sends a bt mesh generic level move message using the app key at the given index to the given addr
+// The node keeps changing its level by deltaLevel per transition time until StopLevelMove is sent
+// transitionTime is in the mesh spec format, the low 6 bits are steps and the high 2 bits the step resolution
+// delay is in 5ms steps, nodes with no default transition time do not move when transitionTime is 0
+func (controller *Controller) SendLevelMove(addr uint16, appIdx uint16, deltaLevel int16, tid uint8, transitionTime uint8, delay uint8) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
+	parms := []byte{OpSendLevelMove}
+	parms = append(parms, toByteSlice(uint16(deltaLevel))...)
+	parms = append(parms, tid, transitionTime, delay)
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
+
+// StopLevelMove stops a level move started with SendLevelMove, tid should be a new one from NewTID
+// It sends a move with a delta of 0 and no transition time or delay so the node stops at once
+func (controller *Controller) StopLevelMove(addr uint16, appIdx uint16, tid uint8) error {
+	return controller.SendLevelMove(addr, appIdx, 0, tid, 0, 0)
+}
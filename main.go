@@ -1,8 +1,14 @@
 package mesh
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gousb"
@@ -31,107 +37,529 @@ const (
 	OpSendDeleteMessage   = 0x18
 	OpSendBindMessage     = 0x19
 	OpEvent               = 0x20
+	OpGetDefaultTTL       = 0x21
+	OpSetDefaultTTL       = 0x22
+	OpDefaultTTLStatus    = 0x23
+	OpProvisionAt         = 0x24
+	OpChunk               = 0x25
+	OpUnbindKey           = 0x26
+	OpRemoveNodeKey       = 0x27
+	OpSetNodeIdentity     = 0x28
+	OpGetNodeIdentity     = 0x29
+	OpNodeIdentityStatus  = 0x30
+	OpSendLevelDelta      = 0x31
+	OpSendLevelMove       = 0x32
+	OpGetSubscriptions    = 0x33
+	OpSubscriptionList    = 0x34
+	OpBusy                = 0x35
+	OpReady               = 0x36
+	OpSetNetworkTransmit  = 0x37
+	OpGetNetworkTransmit  = 0x38
+	OpNetworkTransmit     = 0x39
+	OpListNodes           = 0x40
+	OpNodeInfo            = 0x41
+	OpGetFaults           = 0x42
+	OpFaultStatus         = 0x43
+	OpClearFaults         = 0x44
+	OpSendHSLMessage      = 0x45
+	OpGetHSL              = 0x46
+	OpHSLStatus           = 0x47
+	OpSetMonitorMode      = 0x48
+	OpMonitorFrame        = 0x49
+	OpSendTimeMessage     = 0x50
+	OpGetTime             = 0x51
+	OpTimeStatus          = 0x52
+	OpSendScheduleMessage = 0x53
+	OpGetSchedule         = 0x54
+	OpScheduleStatus      = 0x55
+	OpGetScene            = 0x56
+	OpSceneStatus         = 0x57
+	OpExportDatabase      = 0x58
+	OpDatabaseChunk       = 0x59
+	OpImportDatabase      = 0x60
+	OpImportStatus        = 0x61
+	OpGetComposition      = 0x62
+	OpComposition         = 0x63
+	OpGetNodeFeatures     = 0x64
+	OpNodeFeatures        = 0x65
+	OpStartKeyRefresh     = 0x66
+	OpDistributeKeys      = 0x67
+	OpCompleteKeyRefresh  = 0x68
+	OpKeyRefreshStatus    = 0x69
+	OpCapabilities        = 0x70
+	OpStartProvisioning   = 0x71
+	OpAbortProvisioning   = 0x72
+	OpEnterOOB            = 0x73
+	OpSetNodeDefaultTTL   = 0x74
+	OpSendAccessMessage   = 0x75
+	OpGetSequence         = 0x76
+	OpSequenceStatus      = 0x77
+	OpSetSequence         = 0x78
+	OpGetAppKey           = 0x79
+	OpAppKey              = 0x80
+	OpGetState            = 0x81
+	OpSendFromElement     = 0x82
+	OpGetModelKeys        = 0x83
+	OpModelKeyList        = 0x84
+	OpGetBattery          = 0x85
+	OpBatteryStatus       = 0x86
+	OpGetVendorModelKeys  = 0x87
+	OpVendorModelKeyList  = 0x88
 )
 
+// Fixed group addrs from the mesh spec
+const (
+	AddrAllProxies = 0xFFFC
+	AddrAllFriends = 0xFFFD
+	AddrAllRelays  = 0xFFFE
+	AddrAllNodes   = 0xFFFF
+)
+
+// flushTimeout is how long Flush waits for a queued frame before deciding the queue is empty
+const flushTimeout = 10 * time.Millisecond
+
+// rebootDelay is how long the controller takes to drop off usb after a reboot
+// reopenInterval is how often FactoryReset tries to reopen it afterwards
+const (
+	rebootDelay    = time.Second
+	reopenInterval = 500 * time.Millisecond
+)
+
+// Errors returned by the Mesh Controller
+var (
+	// ErrAddressInUse is returned when an addr is already taken by a known node
+	ErrAddressInUse = errors.New("Address is already used by a node")
+	// ErrClosed is returned when the controller has been closed
+	ErrClosed = errors.New("Controller is closed")
+	// ErrControllerBusy is returned when the controller would drop a command, such as while provisioning
+	ErrControllerBusy = errors.New("Controller is busy")
+	// ErrControllerNotFound is returned when no Mesh Controller is attached or it was unplugged
+	ErrControllerNotFound = errors.New("Controller not found")
+	// ErrNoKeyRefresh is returned when a key refresh step is called with no key refresh started
+	ErrNoKeyRefresh = errors.New("No key refresh in progress")
+	// ErrNoReader is returned by helpers that wait for a reply when Read is not running to receive it
+	ErrNoReader = errors.New("Read is not running")
+	// ErrOpcodeDisabled is returned when writing an op code turned off with DisableOpcodes
+	ErrOpcodeDisabled = errors.New("Op code is disabled")
+	// ErrProvisioningAborted is returned when OnProvisioningCapabilities rejects the device being provisioned
+	ErrProvisioningAborted = errors.New("Provisioning aborted")
+	// ErrUnknownName is returned when no addr has been registered for a name
+	ErrUnknownName = errors.New("Name is not registered")
+	// ErrUnknownNode is returned in strict mode when sending to an addr with no known node or group
+	ErrUnknownNode = errors.New("No known node at address")
+)
+
+// packetWriter is the part of the usb out endpoint used to write packets so tests can fake it
+type packetWriter interface {
+	WriteContext(ctx context.Context, buf []byte) (int, error)
+}
+
 // Controller holds all the needed usb vars to talk to the Mesh Controller
 type Controller struct {
+	usb     OpenConfig
+	match   func(desc *gousb.DeviceDesc) bool
 	context *gousb.Context
 	device  *gousb.Device
 	config  *gousb.Config
 	intf    *gousb.Interface
 	epIn    *gousb.InEndpoint
-	epOut   *gousb.OutEndpoint
+	epOut   packetWriter
+	// outPacketSize is the max packet size of the out endpoint
+	outPacketSize int
+	// MaxMessagesPerSecond limits how many messages are written per second, zero disables the limit
+	MaxMessagesPerSecond int
+	// DefaultAppIdx is the app key index used by the Default send methods
+	DefaultAppIdx uint16
+	// StrictAddressing makes sends to addrs that are not known nodes or groups return ErrUnknownNode
+	// Nodes are known once added, listed with ListNodes or bound to a group with ConfigureElem
+	// The fixed group addrs such as AddrAllNodes are always allowed
+	StrictAddressing bool
+	// OnProvisioningCapabilities is called with the capabilities a device reports while it is provisioned
+	// It returns the OOB method to authenticate with, returning false aborts provisioning
+	// A ProvisionQueue or Commission waiting on the device then fails with ErrProvisioningAborted
+	// When nil devices are provisioned without OOB
+	OnProvisioningCapabilities func(caps Capabilities) (OOBChoice, bool)
+	// CoalesceStates drops OpState frames that repeat the last state seen from the same addr
+	// Dropped frames still reach waiting requests and the event log but not handlers or AllEvents
+	CoalesceStates bool
+	// HandlerWorkers runs handlers on this many goroutines so a slow handler does not stall reads, zero runs them inline
+	// With more than one worker handlers can run concurrently and out of the order frames were read
+	// Handlers that call helpers waiting for a reply, such as ConfigureNodeWait from onNodeAdded, need at least one worker
+	HandlerWorkers int
+	// Backoff sets the delays between retries of failed usb writes
+	Backoff         Backoff
+	writeMu         *sync.Mutex
+	limiter         *rateLimiter
+	pending         *pendingReplies
+	nodes           *nodeTable
+	handlers        *handlerTable
+	eventLog        *eventLog
+	events          *eventSubscribers
+	states          *stateCache
+	pool            *handlerPool
+	provisionFailed chan error
+	keyRefresh      *keyRefresh
+	tid             *uint32
+	busy            *busyState
+	names           *addressBook
+	filter          *sourceFilter
+	invalidFrames   *uint64
+	writeHook       func(op byte, data []byte) error
+	disabled        map[byte]bool
+	closeOnce       *sync.Once
+	closing         context.Context
+	stop            context.CancelFunc
+	readMu          *sync.Mutex
+	readers         *sync.WaitGroup
+	reading         *int32
+	done            chan struct{}
+}
+
+// OpenConfig is the usb layout of the Mesh Controller firmware
+// Endpoints are given by number without the direction bit
+type OpenConfig struct {
+	Config      int
+	Interface   int
+	AltSetting  int
+	InEndpoint  int
+	OutEndpoint int
+}
+
+// DefaultOpenConfig returns the usb layout used by Open
+func DefaultOpenConfig() OpenConfig {
+	return OpenConfig{
+		Config:      1,
+		Interface:   1,
+		AltSetting:  0,
+		InEndpoint:  2,
+		OutEndpoint: 1,
+	}
 }
 
 // Open gets the Mesh Controller using usb
+// The default layout is tried first then the controller's descriptors are searched for its endpoints
 func Open() (Controller, error) {
-	// Get ctx and defer close func
-	ctx := gousb.NewContext()
-	// Get device and defer close func
-	dev, err := ctx.OpenDeviceWithVIDPID(0x2fe3, 0x0100)
+	return OpenWithConfig(DefaultOpenConfig())
+}
+
+// OpenWithConfig gets the Mesh Controller using usb with the given usb layout
+// This is for firmware that exposes its endpoints on another config or interface
+// If the controller does not have the layout the first interface with bulk in and out endpoints is used
+func OpenWithConfig(usb OpenConfig) (Controller, error) {
+	return openMatching(usb, func(desc *gousb.DeviceDesc) bool {
+		return true
+	})
+}
+
+// OpenAtPath gets the Mesh Controller attached at the given usb bus and port chain
+// The port chain lists the hub ports from the root hub to the controller
+// This picks the same controller across reboots when several are attached
+func OpenAtPath(bus int, port []int) (Controller, error) {
+	return openMatching(DefaultOpenConfig(), func(desc *gousb.DeviceDesc) bool {
+		if desc.Bus != bus || len(desc.Path) != len(port) {
+			return false
+		}
+		for i := range port {
+			if desc.Path[i] != port[i] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// openMatching gets the first Mesh Controller whose usb descriptor is accepted by match using the usb layout
+func openMatching(usb OpenConfig, match func(desc *gousb.DeviceDesc) bool) (Controller, error) {
+	controller := newController(usb, match)
+	err := controller.openDevice()
 	if err != nil {
-		return Controller{}, errors.New("Unable to open controller")
+		controller.stop()
+		return Controller{}, err
 	}
+	return controller, nil
+}
+
+// newController makes a Controller for the usb layout and match without opening the device
+func newController(usb OpenConfig, match func(desc *gousb.DeviceDesc) bool) Controller {
+	closing, stop := context.WithCancel(context.Background())
+	return Controller{
+		usb:             usb,
+		match:           match,
+		writeMu:         &sync.Mutex{},
+		limiter:         &rateLimiter{},
+		pending:         newPendingReplies(),
+		nodes:           newNodeTable(),
+		handlers:        newHandlerTable(),
+		eventLog:        &eventLog{},
+		events:          newEventSubscribers(),
+		states:          &stateCache{states: make(map[uint16]byte)},
+		pool:            &handlerPool{},
+		provisionFailed: make(chan error, 1),
+		disabled:        make(map[byte]bool),
+		keyRefresh:      &keyRefresh{},
+		tid:             new(uint32),
+		busy:            &busyState{},
+		names:           &addressBook{addrs: make(map[string]uint16)},
+		filter:          &sourceFilter{},
+		invalidFrames:   new(uint64),
+		closeOnce:       &sync.Once{},
+		closing:         closing,
+		stop:            stop,
+		readMu:          &sync.Mutex{},
+		readers:         &sync.WaitGroup{},
+		reading:         new(int32),
+		done:            make(chan struct{}),
+	}
+}
+
+// openDevice opens the usb device, interface and endpoints of the Mesh Controller
+func (controller *Controller) openDevice() error {
+	// Get ctx and close it if the device can not be opened
+	ctx := gousb.NewContext()
+	fail := func(err error) error {
+		controller.closeDevice()
+		ctx.Close()
+		return err
+	}
+	// Get device
+	found := false
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if found || desc.Vendor != 0x2fe3 || desc.Product != 0x0100 || !controller.match(desc) {
+			return false
+		}
+		found = true
+		return true
+	})
+	if len(devs) == 0 {
+		return fail(ErrControllerNotFound)
+	}
+	dev := devs[0]
+	controller.device = dev
 	// Set auto detach from kernel to true
 	err = dev.SetAutoDetach(true)
 	if err != nil {
-		return Controller{}, errors.New("Unable to open controller")
+		return fail(errors.New("Unable to open controller"))
 	}
-	// Get main config and defer close
-	cfg, err := dev.Config(1)
+	// Find the config and interface holding the endpoints
+	usb, err := findLayout(dev.Desc, controller.usb)
 	if err != nil {
-		return Controller{}, errors.New("Unable to get config")
+		return fail(err)
 	}
-	// Get interface 1 and defer close
-	intf, err := cfg.Interface(1, 0)
+	cfg, err := dev.Config(usb.Config)
 	if err != nil {
-		return Controller{}, errors.New("Unable to open interface")
+		return fail(fmt.Errorf("Unable to get config %d", usb.Config))
 	}
-	// Get out and in endpoints
-	epIn, err := intf.InEndpoint(2)
-	epOut, err := intf.OutEndpoint(1)
+	controller.config = cfg
+	// Get interface
+	intf, err := cfg.Interface(usb.Interface, usb.AltSetting)
 	if err != nil {
-		return Controller{}, errors.New("Unable to open endpoints")
+		return fail(fmt.Errorf("Unable to open interface %d alt %d", usb.Interface, usb.AltSetting))
+	}
+	controller.intf = intf
+	// Get out and in endpoints
+	epIn, err := intf.InEndpoint(usb.InEndpoint)
+	if err != nil || epIn == nil {
+		return fail(fmt.Errorf("Unable to open in endpoint %d", usb.InEndpoint))
 	}
-	// Make struct
-	controller := Controller{
-		context: ctx,
-		device:  dev,
-		config:  cfg,
-		intf:    intf,
-		epIn:    epIn,
-		epOut:   epOut,
+	epOut, err := intf.OutEndpoint(usb.OutEndpoint)
+	if err != nil || epOut == nil {
+		return fail(fmt.Errorf("Unable to open out endpoint %d", usb.OutEndpoint))
 	}
-	return controller, nil
+	// A zero packet size would make Read spin on empty reads
+	if epIn.Desc.MaxPacketSize <= 0 {
+		return fail(errors.New("In endpoint has no max packet size"))
+	}
+	// Out packets must fit a chunk header and at least one byte of data
+	if epOut.Desc.MaxPacketSize <= 2 {
+		return fail(errors.New("Out endpoint max packet size too small"))
+	}
+	controller.context = ctx
+	controller.epIn = epIn
+	controller.epOut = epOut
+	controller.outPacketSize = epOut.Desc.MaxPacketSize
+	return nil
+}
+
+// closeDevice releases the usb resources of the Mesh Controller
+func (controller *Controller) closeDevice() {
+	if controller.intf != nil {
+		controller.intf.Close()
+	}
+	if controller.config != nil {
+		controller.config.Close()
+	}
+	if controller.device != nil {
+		controller.device.Close()
+	}
+	if controller.context != nil {
+		controller.context.Close()
+	}
+	controller.context = nil
+	controller.device = nil
+	controller.config = nil
+	controller.intf = nil
+	controller.epIn = nil
+	controller.epOut = nil
 }
 
 // Close must be called when the Mesh Controller is not needed anymore
+// It stops any running Read and waits for it to return before releasing the usb resources
+// so it is safe to call from another goroutine while Read is blocked, writes after Close return ErrClosed
+// Calling it from a handler deadlocks as it waits for the Read or handler worker making the call
+// so handlers must use go controller.Close() and can wait on Closed in another goroutine
 func (controller *Controller) Close() {
-	controller.intf.Close()
-	controller.config.Close()
-	controller.device.Close()
-	controller.context.Close()
+	controller.closeOnce.Do(func() {
+		// Stop new reads starting then cancel the running ones
+		controller.readMu.Lock()
+		controller.stop()
+		controller.readMu.Unlock()
+		controller.readers.Wait()
+		// Wait for any write in progress
+		controller.writeMu.Lock()
+		controller.closeDevice()
+		controller.writeMu.Unlock()
+		close(controller.done)
+	})
+}
+
+// Closed returns a channel that is closed once Close has released the usb resources
+func (controller *Controller) Closed() <-chan struct{} {
+	return controller.done
+}
+
+// IsOpen reports if Close has not been called on the Mesh Controller
+func (controller *Controller) IsOpen() bool {
+	return controller.closing.Err() == nil
+}
+
+// Serial reads the usb serial number of the Mesh Controller
+func (controller *Controller) Serial() (string, error) {
+	// The device is gone once closed and while FactoryReset reopens it
+	if !controller.IsOpen() || controller.device == nil {
+		return "", ErrClosed
+	}
+	serial, err := controller.device.SerialNumber()
+	if err != nil {
+		return "", errors.New("Unable to read serial number")
+	}
+	return serial, nil
+}
+
+// BusAddress returns the usb bus number and device address of the Mesh Controller
+// Both are zero once the controller is closed
+func (controller *Controller) BusAddress() (int, int) {
+	if controller.device == nil {
+		return 0, 0
+	}
+	return controller.device.Desc.Bus, controller.device.Desc.Address
 }
 
 // Read calls the provided funcs when a msg from the Mesh Controller is received
+// The funcs become the handlers for their op codes and can be swapped with SetHandler while Read runs
+// Read returns ErrClosed once Close is called and ErrControllerNotFound if the controller is unplugged
+// Every helper that waits for a reply gets it through Read and returns ErrNoReader when Read is not running
+// Replies a helper waits for are still passed to the handlers
+// Inline handlers block Read so helpers that wait for a reply must not be called from them
+// as the reply is never read and the helper times out, set HandlerWorkers to call them from a handler
 func (controller *Controller) Read(
 	onSetupStatus func(),
 	onAddKeyStatus func(appIdx uint16),
 	onUnprovisionedBeacon func(uuid []byte),
-	onNodeAdded func(addr uint16),
+	onNodeAdded func(addr uint16, elementCount uint8),
 	onState func(addr uint16, state byte),
 	onEvent func(addr uint16),
+	onVendorEvent func(addr uint16, companyID uint16, opcode byte, payload []byte),
 ) error {
+	// Register with Close so it waits for this read loop to return
+	controller.readMu.Lock()
+	if controller.closing.Err() != nil {
+		controller.readMu.Unlock()
+		return ErrClosed
+	}
+	controller.readers.Add(1)
+	controller.readMu.Unlock()
+	defer controller.readers.Done()
+	if controller.epIn == nil {
+		return ErrControllerNotFound
+	}
+	// Let request helpers know there is a loop to deliver their replies
+	atomic.AddInt32(controller.reading, 1)
+	defer atomic.AddInt32(controller.reading, -1)
+	// Map to provided function
+	controller.SetHandler(OpSetupStatus, func(frame []byte) {
+		onSetupStatus()
+	})
+	controller.SetHandler(OpAddKeyStatus, func(frame []byte) {
+		onAddKeyStatus(fromByteSlice(frame[1:3]))
+	})
+	controller.SetHandler(OpUnprovisionedBeacon, func(frame []byte) {
+		onUnprovisionedBeacon(frame[1:17])
+	})
+	controller.SetHandler(OpNodeAdded, func(frame []byte) {
+		onNodeAdded(fromByteSlice(frame[1:3]), addedElements(frame))
+	})
+	controller.SetHandler(OpState, func(frame []byte) {
+		onState(fromByteSlice(frame[1:3]), frame[3])
+	})
+	controller.SetHandler(OpEvent, func(frame []byte) {
+		// Events from vendor models carry a 3 byte opcode and payload after the addr
+		if len(frame) >= 6 && frame[3]&0xC0 == 0xC0 {
+			onVendorEvent(fromByteSlice(frame[1:3]), fromByteSlice(frame[4:6]), frame[3]&0x3F, frame[6:])
+			return
+		}
+		onEvent(fromByteSlice(frame[1:3]))
+	})
+	if controller.HandlerWorkers > 0 {
+		controller.pool.start(controller.HandlerWorkers)
+		defer controller.pool.stop()
+	}
 	for {
 		// Read a packet
 		buf := make([]byte, controller.epIn.Desc.MaxPacketSize)
-		controller.epIn.Read(buf)
-		// if err != nil {
-		// 	if err != gousb.ErrorOverflow && err != gousb.TransferNoDevice && err != gousb.ErrorIO {
-		// 		// return errors.New("Failed to read message")
-		// 		log.Fatal(err)
-		// 	}
-		// 	// If overflow discard message
-		// 	continue
-		// }
-		// Map to provided function
-		if buf[0] == OpSetupStatus {
-			onSetupStatus()
-		}
-		if buf[0] == OpAddKeyStatus {
-			onAddKeyStatus(binary.LittleEndian.Uint16(buf[1:3]))
+		n, err := controller.epIn.ReadContext(controller.closing, buf)
+		// Stamp the frame before any handler runs
+		received := time.Now()
+		if controller.closing.Err() != nil {
+			return ErrClosed
 		}
-		if buf[0] == OpUnprovisionedBeacon {
-			onUnprovisionedBeacon(buf[1:17])
+		if err != nil {
+			// Give up if the controller is gone instead of spinning on failed reads
+			if !retryable(err) && err != gousb.ErrorOverflow && err != gousb.TransferOverflow {
+				return usbError(controller.closing, err, errors.New("Failed to read message"))
+			}
+			// If overflow or timeout discard message
+			continue
 		}
-		if buf[0] == OpNodeAdded {
-			onNodeAdded(binary.LittleEndian.Uint16(buf[1:3]))
+		if n == 0 {
+			continue
 		}
-		if buf[0] == OpState {
-			onState(binary.LittleEndian.Uint16(buf[1:3]), buf[3])
+		controller.dispatch(buf[:n], received)
+	}
+}
+
+// SetHandler sets the func called with every frame that has the given op code, nil drops those frames
+// It is safe to call while Read is running
+func (controller *Controller) SetHandler(op byte, fn func(frame []byte)) {
+	controller.handlers.set(op, fn)
+}
+
+// Flush discards any inbound frames already queued on the Mesh Controller
+// It must be called before Read is started as Read would otherwise consume the frames
+func (controller *Controller) Flush() error {
+	if controller.epIn == nil {
+		return ErrClosed
+	}
+	buf := make([]byte, controller.epIn.Desc.MaxPacketSize)
+	for {
+		// Give up on each read quickly so an empty queue ends the flush
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		_, err := controller.epIn.ReadContext(ctx, buf)
+		cancel()
+		if ctx.Err() != nil {
+			return nil
 		}
-		if buf[0] == OpEvent {
-			onEvent(binary.LittleEndian.Uint16(buf[1:3]))
+		if err != nil {
+			return errors.New("Unable to flush controller")
 		}
 	}
 }
@@ -140,7 +568,12 @@ func (controller *Controller) Read(
 func (controller *Controller) ResetNode(addr uint16) error {
 	parms := []byte{OpNodeReset}
 	parms = append(parms, toByteSlice(addr)...)
-	return controller.WriteData(parms)
+	err := controller.WriteData(parms)
+	if err != nil {
+		return err
+	}
+	controller.nodes.remove(addr)
+	return nil
 }
 
 // Reboot reboots the Mesh Controller must be called after reset
@@ -148,13 +581,67 @@ func (controller *Controller) Reboot() error {
 	return controller.WriteData([]byte{OpReboot})
 }
 
+// FactoryReset wipes the Mesh Controller and sets up a new network
+// It resets and reboots the controller, waits for it to enumerate on usb again, reopens it and calls Setup
+// Read must not be running as the usb endpoints are replaced
+// If ctx is done before the controller is back ctx.Err is returned and the controller is left unopened but not closed
+// so the caller can Close it or call FactoryReset again, writes then return ErrControllerNotFound
+// A Close while waiting returns ErrClosed
+func (controller *Controller) FactoryReset(ctx context.Context) error {
+	err := controller.Reset()
+	if err != nil {
+		return err
+	}
+	controller.nodes.clear()
+	err = controller.Reboot()
+	if err != nil {
+		return err
+	}
+	controller.writeMu.Lock()
+	controller.closeDevice()
+	// The new network starts with nothing in progress
+	controller.busy.clear()
+	controller.keyRefresh.reset()
+	controller.states.clear()
+	// Give the controller time to drop off the bus then poll until it is back
+	delay := rebootDelay
+	for {
+		select {
+		case <-ctx.Done():
+			controller.writeMu.Unlock()
+			return ctx.Err()
+		case <-controller.closing.Done():
+			controller.writeMu.Unlock()
+			return ErrClosed
+		case <-time.After(delay):
+		}
+		if controller.openDevice() == nil {
+			break
+		}
+		delay = reopenInterval
+	}
+	controller.writeMu.Unlock()
+	return controller.Setup()
+}
+
 // Reset removes all mesh related items from the Mesh Controller's flash
+// A provisioning still in progress is aborted first
 func (controller *Controller) Reset() error {
+	if controller.busy.isBusy() {
+		err := controller.AbortProvisioning()
+		if err != nil {
+			return err
+		}
+	}
 	return controller.WriteData([]byte{OpReset})
 }
 
 // SendMessage sends a bt mesh message using the app key at the given index to the given addr
 func (controller *Controller) SendMessage(state byte, addr uint16, appIdx uint16) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
 	parms := []byte{OpSendMessage}
 	parms = append(parms, state)
 	parms = append(parms, toByteSlice(addr)...)
@@ -162,8 +649,35 @@ func (controller *Controller) SendMessage(state byte, addr uint16, appIdx uint16
 	return controller.WriteData(parms)
 }
 
+// SendMessageDefault sends a bt mesh message using the app key at DefaultAppIdx to the given addr
+func (controller *Controller) SendMessageDefault(state byte, addr uint16) error {
+	return controller.SendMessage(state, addr, controller.DefaultAppIdx)
+}
+
+// SendFromElement sends a bt mesh message like SendMessage from the elem at srcElem of the Mesh Controller
+// Replies are addressed to that elem so several apps on the controller can each get their own
+// srcElem 0 is the primary elem used by SendMessage
+func (controller *Controller) SendFromElement(srcElem int, state byte, addr uint16, appIdx uint16) error {
+	if srcElem < 0 || srcElem > 0xFF {
+		return errors.New("Invalid source element")
+	}
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
+	parms := []byte{OpSendFromElement}
+	parms = append(parms, state, byte(srcElem))
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
+
 // SendRecallMessage sends a bt mesh scene recall message using the app key at the given index to the given addr
 func (controller *Controller) SendRecallMessage(sceneNumber uint16, addr uint16, appIdx uint16) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
 	parms := []byte{OpSendRecallMessage}
 	parms = append(parms, toByteSlice(sceneNumber)...)
 	parms = append(parms, toByteSlice(addr)...)
@@ -171,8 +685,17 @@ func (controller *Controller) SendRecallMessage(sceneNumber uint16, addr uint16,
 	return controller.WriteData(parms)
 }
 
+// SendRecallMessageDefault sends a bt mesh scene recall message using the app key at DefaultAppIdx to the given addr
+func (controller *Controller) SendRecallMessageDefault(sceneNumber uint16, addr uint16) error {
+	return controller.SendRecallMessage(sceneNumber, addr, controller.DefaultAppIdx)
+}
+
 // SendStoreMessage sends a bt mesh scene store message using the app key at the given index to the given addr
 func (controller *Controller) SendStoreMessage(sceneNumber uint16, addr uint16, appIdx uint16) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
 	parms := []byte{OpSendStoreMessage}
 	parms = append(parms, toByteSlice(sceneNumber)...)
 	parms = append(parms, toByteSlice(addr)...)
@@ -182,6 +705,10 @@ func (controller *Controller) SendStoreMessage(sceneNumber uint16, addr uint16,
 
 // SendDeleteMessage sends a bt mesh scene delete message using the app key at the given index to the given addr
 func (controller *Controller) SendDeleteMessage(sceneNumber uint16, addr uint16, appIdx uint16) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
 	parms := []byte{OpSendDeleteMessage}
 	parms = append(parms, toByteSlice(sceneNumber)...)
 	parms = append(parms, toByteSlice(addr)...)
@@ -191,6 +718,10 @@ func (controller *Controller) SendDeleteMessage(sceneNumber uint16, addr uint16,
 
 // SendBindMessage sends a bt mesh event bind message using the app key at the given index to the given addr
 func (controller *Controller) SendBindMessage(sceneNumber uint16, addr uint16, appIdx uint16) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
 	parms := []byte{OpSendBindMessage}
 	parms = append(parms, toByteSlice(sceneNumber)...)
 	parms = append(parms, toByteSlice(addr)...)
@@ -206,6 +737,21 @@ func (controller *Controller) ConfigureNode(addr uint16, appIdx uint16) error {
 	return controller.WriteData(parms)
 }
 
+// ConfigureNodeWait binds an app key to the node with the given addr and waits for the node to confirm it
+// A node that refuses the key returns a ConfigStatus error
+// Read must be running for the reply to be received
+func (controller *Controller) ConfigureNodeWait(ctx context.Context, addr uint16, appIdx uint16) error {
+	parms := []byte{OpConfigureNode}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpConfigureNodeStatus, addr)
+	if err != nil {
+		return err
+	}
+	// Reply is addr then status
+	return configError(reply[3])
+}
+
 // ConfigureElem binds an app key to the elem with the given addr
 func (controller *Controller) ConfigureElem(groupAddr uint16, nodeAddr uint16, elemAddr uint16, appIdx uint16) error {
 	parms := []byte{OpConfigureElem}
@@ -213,14 +759,139 @@ func (controller *Controller) ConfigureElem(groupAddr uint16, nodeAddr uint16, e
 	parms = append(parms, toByteSlice(nodeAddr)...)
 	parms = append(parms, toByteSlice(elemAddr)...)
 	parms = append(parms, toByteSlice(appIdx)...)
+	err := controller.WriteData(parms)
+	if err != nil {
+		return err
+	}
+	controller.nodes.addGroup(groupAddr)
+	return nil
+}
+
+// configureElemWait is ConfigureElem waiting for the node to report the elem configured
+// The reply is the node addr, elem addr and app key index echoed back then the config status
+func (controller *Controller) configureElemWait(ctx context.Context, groupAddr uint16, nodeAddr uint16, elemAddr uint16, appIdx uint16) error {
+	parms := []byte{OpConfigureElem}
+	parms = append(parms, toByteSlice(groupAddr)...)
+	parms = append(parms, toByteSlice(nodeAddr)...)
+	parms = append(parms, toByteSlice(elemAddr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpConfigureElemStatus, nodeAddr)
+	if err != nil {
+		return err
+	}
+	// Replies are keyed by node so one for another elem or key on the same node must not be taken as success
+	if fromByteSlice(reply[3:5]) != elemAddr || fromByteSlice(reply[5:7]) != appIdx {
+		return errors.New("Configure elem reply for wrong elem or key")
+	}
+	err = configError(reply[7])
+	if err != nil {
+		return err
+	}
+	controller.nodes.addGroup(groupAddr)
+	return nil
+}
+
+// ConfigureElemKeys binds each of the app keys at the given indexes to the elem with the given addr
+// Every key is attempted and confirmed by the node, a *BindKeysError lists which ones failed
+// Keys the node refuses fail with a ConfigStatus error and keys with no reply with the timeout
+// Read must be running for the replies to be received
+func (controller *Controller) ConfigureElemKeys(groupAddr uint16, nodeAddr uint16, elemAddr uint16, appIdxs []uint16) error {
+	bindErr := &BindKeysError{Failed: make(map[uint16]error)}
+	for _, appIdx := range appIdxs {
+		ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+		err := controller.configureElemWait(ctx, groupAddr, nodeAddr, elemAddr, appIdx)
+		cancel()
+		if err != nil {
+			bindErr.Failed[appIdx] = err
+			continue
+		}
+		bindErr.Bound = append(bindErr.Bound, appIdx)
+	}
+	if len(bindErr.Failed) > 0 {
+		return bindErr
+	}
+	return nil
+}
+
+// BindKeysError reports the app keys that were and were not bound by ConfigureElemKeys
+type BindKeysError struct {
+	Bound  []uint16
+	Failed map[uint16]error
+}
+
+func (bindErr *BindKeysError) Error() string {
+	failed := make([]string, 0, len(bindErr.Failed))
+	for appIdx, err := range bindErr.Failed {
+		failed = append(failed, fmt.Sprintf("%d (%s)", appIdx, err))
+	}
+	sort.Strings(failed)
+	return fmt.Sprintf("Unable to bind app keys %s, bound %v", strings.Join(failed, ", "), bindErr.Bound)
+}
+
+// UnbindKey removes the binding of the app key at the given index from a model of the elem with the given addr
+func (controller *Controller) UnbindKey(elemAddr uint16, modelID uint16, appIdx uint16) error {
+	parms := []byte{OpUnbindKey}
+	parms = append(parms, toByteSlice(elemAddr)...)
+	parms = append(parms, toByteSlice(modelID)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
+
+// RemoveAppKeyFromNode deletes the app key at the given index from the node with the given addr
+// This also removes every binding of that key on the node
+func (controller *Controller) RemoveAppKeyFromNode(addr uint16, appIdx uint16) error {
+	parms := []byte{OpRemoveNodeKey}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
 	return controller.WriteData(parms)
 }
 
 // Provision adds a device with the given uuid to the network
+// Other commands return ErrControllerBusy until the device has been added or AbortProvisioning is called
 func (controller *Controller) Provision(uuid []byte) error {
 	parms := []byte{OpProvision}
 	parms = append(parms, uuid...)
-	return controller.WriteData(parms)
+	err := controller.WriteData(parms)
+	if err != nil {
+		return err
+	}
+	controller.busy.set()
+	return nil
+}
+
+// AbortProvisioning stops the device being provisioned by Provision or ProvisionAt
+// The controller then takes other commands again without waiting for the provisioning to time out
+func (controller *Controller) AbortProvisioning() error {
+	err := controller.WriteData([]byte{OpAbortProvisioning})
+	if err != nil {
+		return err
+	}
+	controller.busy.clear()
+	return nil
+}
+
+// ProvisionAt adds a device with the given uuid to the network at the given unicast addr
+// Addrs already used by an elem of a node added since Open return ErrAddressInUse
+func (controller *Controller) ProvisionAt(uuid []byte, addr uint16) error {
+	if len(uuid) != 16 {
+		return errors.New("Invalid uuid")
+	}
+	// Unicast addrs are 0x0001 to 0x7FFF
+	if addr == 0 || addr > 0x7FFF {
+		return errors.New("Invalid unicast address")
+	}
+	if controller.nodes.occupied(addr) {
+		return ErrAddressInUse
+	}
+	parms := []byte{OpProvisionAt}
+	parms = append(parms, uuid...)
+	parms = append(parms, toByteSlice(addr)...)
+	err := controller.WriteData(parms)
+	if err != nil {
+		return err
+	}
+	controller.busy.set()
+	return nil
 }
 
 // AddKey generates an app key at the given index
@@ -230,30 +901,248 @@ func (controller *Controller) AddKey(appIdx uint16) error {
 	return controller.WriteData(parms)
 }
 
+// GetAppKey reads the 16 byte app key at the given index from the Mesh Controller
+// Anyone holding the key can read and send messages for every model bound to it
+// so it should only be shared with trusted provisioners or apps and never logged
+// Read must be running for the reply to be received
+func (controller *Controller) GetAppKey(appIdx uint16) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+	parms := []byte{OpGetAppKey}
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpAppKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	// Reply is app key index, status then the key
+	// Replies are not keyed by index so one for another concurrent call must not be returned
+	if fromByteSlice(reply[1:3]) != appIdx {
+		return nil, errors.New("App key reply for wrong index")
+	}
+	if reply[3] != 0 {
+		return nil, errors.New("No app key at index")
+	}
+	return append([]byte(nil), reply[4:20]...), nil
+}
+
+// GetDefaultTTL reads the default TTL the Mesh Controller uses when sending messages
+// Read must be running for the reply to be received
+func (controller *Controller) GetDefaultTTL() (uint8, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+	reply, err := controller.request(ctx, []byte{OpGetDefaultTTL}, OpDefaultTTLStatus, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(reply) < 2 {
+		return 0, errors.New("Invalid default ttl reply")
+	}
+	return reply[1], nil
+}
+
+// SetDefaultTTL sets the default TTL the Mesh Controller uses when sending messages
+func (controller *Controller) SetDefaultTTL(ttl uint8) error {
+	// The mesh spec prohibits a ttl of 1 and values above 127
+	if ttl == 1 || ttl > 0x7F {
+		return errors.New("Invalid ttl")
+	}
+	return controller.WriteData([]byte{OpSetDefaultTTL, ttl})
+}
+
 // Setup creates a new bt mesh network
 func (controller *Controller) Setup() error {
 	return controller.WriteData([]byte{OpSetup})
 }
 
+// NewTID returns the next transaction identifier for messages that need one
+// Messages that are retransmitted or are part of one gesture must reuse the same tid
+func (controller *Controller) NewTID() uint8 {
+	return uint8(atomic.AddUint32(controller.tid, 1))
+}
+
 // WriteData writes data to the Mesh Controller over usb
+// Writes are serialized and paced by MaxMessagesPerSecond when it is set
+// Data larger than the out endpoint's max packet size is split into OpChunk frames
+// Each chunk frame is OpChunk, a header byte then part of data
+// The low 7 bits of the header are the chunk index and the high bit marks the final chunk
+// Data needing more than 128 chunks is rejected as the index would wrap
+// The firmware joins the chunk payloads in order to rebuild data
 func (controller *Controller) WriteData(data []byte) error {
-	_, err := controller.epOut.Write(data)
-	if err != nil {
-		// If write fails retry after a delay
-		time.Sleep(200 * time.Millisecond)
-		_, err = controller.epOut.Write(data)
-
-		// If write fails again error out
+	controller.writeMu.Lock()
+	defer controller.writeMu.Unlock()
+	if controller.closing.Err() != nil {
+		return ErrClosed
+	}
+	// FactoryReset leaves the device unopened if it does not come back
+	if controller.epOut == nil {
+		return ErrControllerNotFound
+	}
+	if len(data) > 0 && controller.disabled[data[0]] {
+		return ErrOpcodeDisabled
+	}
+	// Refuse commands the controller would drop while busy
+	if len(data) > 0 && !busyExemptOps[data[0]] && controller.busy.isBusy() {
+		return ErrControllerBusy
+	}
+	// Let the write hook veto the frame
+	if controller.writeHook != nil && len(data) > 0 {
+		err := controller.writeHook(data[0], data)
+		if err != nil {
+			return err
+		}
+	}
+	// Wait for a token if rate limited, Close stops the wait
+	if controller.MaxMessagesPerSecond > 0 {
+		err := controller.limiter.wait(controller.MaxMessagesPerSecond, controller.closing.Done())
 		if err != nil {
-			return errors.New("Write failed")
+			return err
+		}
+	}
+	maxSize := controller.outPacketSize
+	if len(data) <= maxSize {
+		return controller.writePacket(data)
+	}
+	// Split data into chunks that fit with the chunk header
+	chunkSize := maxSize - 2
+	chunks := (len(data) + chunkSize - 1) / chunkSize
+	if chunks > 0x80 {
+		return errors.New("Data too large")
+	}
+	for i := 0; i < chunks; i++ {
+		end := (i + 1) * chunkSize
+		header := byte(i)
+		if end >= len(data) {
+			end = len(data)
+			header |= 0x80
+		}
+		packet := []byte{OpChunk, header}
+		packet = append(packet, data[i*chunkSize:end]...)
+		err := controller.writePacket(packet)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetWriteHook sets a func that sees every frame before WriteData sends it, nil removes the hook
+// Returning an error from hook aborts the write with that error
+// The hook is called while writes are locked so it must not write to the controller
+func (controller *Controller) SetWriteHook(hook func(op byte, data []byte) error) {
+	controller.writeMu.Lock()
+	controller.writeHook = hook
+	controller.writeMu.Unlock()
+}
+
+// DisableOpcodes makes WriteData return ErrOpcodeDisabled for frames with any of the given op codes
+// Disabling OpReset, OpReboot and OpNodeReset keeps a bug from wiping the network
+// Op codes can not be enabled again without opening the controller again
+func (controller *Controller) DisableOpcodes(ops ...byte) {
+	controller.writeMu.Lock()
+	for _, op := range ops {
+		controller.disabled[op] = true
+	}
+	controller.writeMu.Unlock()
+}
+
+// writePacket writes a single usb packet to the Mesh Controller
+// Closing the controller cancels the write and its retry delay
+// Only transient usb errors are retried, an unplugged controller returns ErrControllerNotFound
+func (controller *Controller) writePacket(packet []byte) error {
+	backoff := controller.Backoff.withDefaults()
+	_, err := controller.epOut.WriteContext(controller.closing, packet)
+	for retry := 0; err != nil; retry++ {
+		// If write fails for good error out
+		if !retryable(err) || retry == backoff.Retries {
+			return usbError(controller.closing, err, errors.New("Write failed"))
+		}
+		// Otherwise retry after a delay
+		select {
+		case <-time.After(backoff.delay(retry)):
+		case <-controller.closing.Done():
+			return ErrClosed
 		}
+		_, err = controller.epOut.WriteContext(controller.closing, packet)
+	}
+	return nil
+}
+
+// retryable reports if a failed usb transfer may succeed when tried again
+func retryable(err error) bool {
+	switch err {
+	case gousb.ErrorTimeout, gousb.ErrorBusy, gousb.ErrorInterrupted, gousb.TransferTimedOut:
+		return true
+	}
+	return false
+}
+
+// usbError maps a failed usb transfer to the error returned to callers, failed is used for errors with no better match
+func usbError(closing context.Context, err error, failed error) error {
+	if closing.Err() != nil {
+		return ErrClosed
+	}
+	switch err {
+	case gousb.TransferNoDevice, gousb.ErrorNoDevice:
+		return ErrControllerNotFound
+	}
+	return failed
+}
+
+// request writes data and waits for the reply with the given op code from addr
+func (controller *Controller) request(ctx context.Context, data []byte, op byte, addr uint16) ([]byte, error) {
+	err := controller.checkReader()
+	if err != nil {
+		return nil, err
+	}
+	// Register before writing so a fast reply is not missed
+	w := controller.pending.add(waitKey{op: op, addr: addr})
+	defer controller.pending.remove(w)
+	err = controller.WriteData(data)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-w.frame:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-controller.closing.Done():
+		return nil, ErrClosed
+	}
+}
+
+// checkReader returns ErrNoReader if Read is not running
+// Replies only reach waiters through the read loop so helpers that wait must not read usb themselves
+// nor be called from an inline handler, which would stop the loop until they time out
+func (controller *Controller) checkReader() error {
+	if atomic.LoadInt32(controller.reading) == 0 {
+		return ErrNoReader
 	}
 	return nil
 }
 
+// byteOrder is the order of the firmware's own multi-byte frame fields such as addrs and key indexes
+// and of the model message parameters it passes through, which are little-endian in the mesh spec
+// Access layer opcodes, provisioning pdus and network headers are big-endian in the spec and must not use it
+var byteOrder = binary.LittleEndian
+
 // Only works with unsigned 16 bit numbers
 func toByteSlice(input uint16) []byte {
 	bytes := []byte{0x00, 0x00}
-	binary.LittleEndian.PutUint16(bytes, input)
+	byteOrder.PutUint16(bytes, input)
 	return bytes
 }
+
+// fromByteSlices reads a list of unsigned 16 bit numbers, a trailing odd byte is ignored
+func fromByteSlices(input []byte) []uint16 {
+	output := make([]uint16, 0, len(input)/2)
+	for i := 0; i+1 < len(input); i += 2 {
+		output = append(output, fromByteSlice(input[i:i+2]))
+	}
+	return output
+}
+
+// fromByteSlice reads an unsigned 16 bit number from the first two bytes of input
+func fromByteSlice(input []byte) uint16 {
+	return byteOrder.Uint16(input)
+}
@@ -0,0 +1,70 @@
+package mesh
+
+import (
+	"context"
+	"time"
+)
+
+// Mesh time counts TAI seconds from 2000-01-01T00:00:00 TAI
+// taiEpochUnix is that date as a unix time and taiUTCDelta is the current TAI-UTC difference, unchanged since 2017
+const (
+	taiEpochUnix = 946684800
+	taiUTCDelta  = 37
+)
+
+// SetTime sends a bt mesh time set message using the app key at the given index to the given addr
+// The node's zone offset is taken from the location of t
+func (controller *Controller) SetTime(addr uint16, appIdx uint16, t time.Time) error {
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
+	parms := []byte{OpSendTimeMessage}
+	parms = append(parms, encodeTime(t)...)
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
+
+// GetTime reads the time of the node with the given addr using the app key at the given index
+// The returned time is in the node's zone offset
+func (controller *Controller) GetTime(ctx context.Context, addr uint16, appIdx uint16) (time.Time, error) {
+	parms := []byte{OpGetTime}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpTimeStatus, addr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return decodeTime(reply[3:]), nil
+}
+
+// encodeTime packs t into the 10 byte mesh time state
+// 5 bytes of TAI seconds, a subsecond in 1/256 s, uncertainty, a time authority bit with the 15 bit TAI-UTC delta and the zone offset
+func encodeTime(t time.Time) []byte {
+	state := make([]byte, 10)
+	seconds := uint64(t.Unix() - taiEpochUnix + taiUTCDelta)
+	for i := 0; i < 5; i++ {
+		state[i] = byte(seconds >> (8 * i))
+	}
+	state[5] = byte(int64(t.Nanosecond()) * 256 / int64(time.Second))
+	// No uncertainty and the host clock is authoritative
+	state[6] = 0x00
+	byteOrder.PutUint16(state[7:9], uint16(taiUTCDelta+255)<<1|0x01)
+	// Zone offset is in 15 minute steps from -64
+	_, offset := t.Zone()
+	state[9] = byte(offset/(15*60) + 64)
+	return state
+}
+
+// decodeTime unpacks a 10 byte mesh time state
+func decodeTime(state []byte) time.Time {
+	var seconds int64
+	for i := 0; i < 5; i++ {
+		seconds |= int64(state[i]) << (8 * i)
+	}
+	nanos := int64(state[5]) * int64(time.Second) / 256
+	delta := int64(fromByteSlice(state[7:9])>>1) - 255
+	offset := (int(state[9]) - 64) * 15 * 60
+	return time.Unix(seconds+taiEpochUnix-delta, nanos).In(time.FixedZone("", offset))
+}
@@ -0,0 +1,40 @@
+package mesh
+
+import "context"
+
+// SIG model ids from the mesh spec
+const (
+	ModelConfigServer         = 0x0000
+	ModelConfigClient         = 0x0001
+	ModelHealthServer         = 0x0002
+	ModelHealthClient         = 0x0003
+	ModelGenericOnOffServer   = 0x1000
+	ModelGenericOnOffClient   = 0x1001
+	ModelGenericLevelServer   = 0x1002
+	ModelGenericLevelClient   = 0x1003
+	ModelGenericBatteryServer = 0x100C
+	ModelGenericBatteryClient = 0x100D
+	ModelTimeServer           = 0x1200
+	ModelTimeClient           = 0x1202
+	ModelSceneServer          = 0x1203
+	ModelSceneClient          = 0x1205
+	ModelSchedulerServer      = 0x1206
+	ModelSchedulerClient      = 0x1208
+	ModelLightCTLServer       = 0x1303
+	ModelLightCTLClient       = 0x1305
+	ModelLightHSLServer       = 0x1307
+	ModelLightHSLClient       = 0x1309
+)
+
+// SupportedModels reads the ids of the SIG models in the Mesh Controller's own composition
+// A client model such as ModelLightHSLClient must be present for its send and get methods to work
+// Read must be running for the reply to be received
+func (controller *Controller) SupportedModels() ([]uint16, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+	reply, err := controller.request(ctx, []byte{OpGetComposition}, OpComposition, 0)
+	if err != nil {
+		return nil, err
+	}
+	return fromByteSlices(reply[1:]), nil
+}
@@ -0,0 +1,36 @@
+package mesh
+
+import "sync"
+
+// addressBook maps names given by the app to addrs
+type addressBook struct {
+	mu    sync.RWMutex
+	addrs map[string]uint16
+}
+
+// RegisterName names the given addr so it can be used with SendMessageTo, registering a name again replaces its addr
+func (controller *Controller) RegisterName(name string, addr uint16) {
+	controller.names.mu.Lock()
+	controller.names.addrs[name] = addr
+	controller.names.mu.Unlock()
+}
+
+// SendMessageTo sends a bt mesh message using the app key at the given index to the addr registered as name
+func (controller *Controller) SendMessageTo(name string, state byte, appIdx uint16) error {
+	addr, err := controller.resolveName(name)
+	if err != nil {
+		return err
+	}
+	return controller.SendMessage(state, addr, appIdx)
+}
+
+// resolveName returns the addr registered as name
+func (controller *Controller) resolveName(name string) (uint16, error) {
+	controller.names.mu.RLock()
+	defer controller.names.mu.RUnlock()
+	addr, ok := controller.names.addrs[name]
+	if !ok {
+		return 0, ErrUnknownName
+	}
+	return addr, nil
+}
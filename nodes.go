@@ -0,0 +1,188 @@
+package mesh
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// NodeInfo describes a node provisioned on the network
+type NodeInfo struct {
+	// Addr is the unicast addr of the node's primary elem
+	Addr     uint16
+	Elements uint8
+	AppKeys  []uint16
+}
+
+// ListNodes asks the Mesh Controller for every node it has provisioned
+// Read must be running for the reply to be received
+func (controller *Controller) ListNodes() ([]NodeInfo, error) {
+	err := controller.checkReader()
+	if err != nil {
+		return nil, err
+	}
+	w := controller.pending.addStream(waitKey{op: OpNodeInfo})
+	defer controller.pending.remove(w)
+	err = controller.WriteData([]byte{OpListNodes})
+	if err != nil {
+		return nil, err
+	}
+	// The controller replies with a frame per node, each holding its index and the total
+	var nodes []NodeInfo
+	timer := time.NewTimer(responseTimeout)
+	defer timer.Stop()
+	for {
+		var reply []byte
+		select {
+		case reply = <-w.frame:
+		case <-timer.C:
+			return nil, errors.New("Timed out listing nodes")
+		}
+		index := fromByteSlice(reply[1:3])
+		total := fromByteSlice(reply[3:5])
+		if total == 0 {
+			break
+		}
+		if len(reply) < 8 || int(index) != len(nodes) {
+			return nil, errors.New("Invalid node list")
+		}
+		nodes = append(nodes, NodeInfo{
+			Addr:     fromByteSlice(reply[5:7]),
+			Elements: reply[7],
+			AppKeys:  fromByteSlices(reply[8:]),
+		})
+		if len(nodes) == int(total) {
+			break
+		}
+		timer.Reset(responseTimeout)
+	}
+	controller.nodes.replace(nodes)
+	return nodes, nil
+}
+
+// checkDest returns ErrUnknownNode in strict mode if addr is not a known node elem or group
+func (controller *Controller) checkDest(addr uint16) error {
+	if !controller.StrictAddressing || controller.nodes.knows(addr) {
+		return nil
+	}
+	return ErrUnknownNode
+}
+
+// SendToElement sends a bt mesh message to the elem at elementIndex of the node with the given primary addr
+// The node must be known and the index below its elem count, nodes with no known elem count have one elem
+func (controller *Controller) SendToElement(nodeAddr uint16, elementIndex int, state byte, appIdx uint16) error {
+	node, ok := controller.nodes.get(nodeAddr)
+	if !ok {
+		return ErrUnknownNode
+	}
+	elements := int(node.Elements)
+	if elements == 0 {
+		elements = 1
+	}
+	if elementIndex < 0 || elementIndex >= elements {
+		return errors.New("Element index out of range")
+	}
+	return controller.SendMessage(state, nodeAddr+uint16(elementIndex), appIdx)
+}
+
+// nodeTable tracks the nodes known to be on the network by their unicast addr and the groups they were added to
+type nodeTable struct {
+	mu     sync.Mutex
+	nodes  map[uint16]NodeInfo
+	groups map[uint16]bool
+}
+
+func newNodeTable() *nodeTable {
+	return &nodeTable{nodes: make(map[uint16]NodeInfo), groups: make(map[uint16]bool)}
+}
+
+// addGroup records a group elems have been added to
+func (table *nodeTable) addGroup(addr uint16) {
+	table.mu.Lock()
+	table.groups[addr] = true
+	table.mu.Unlock()
+}
+
+// knows reports if addr is a fixed or known group or belongs to an elem of a known node
+func (table *nodeTable) knows(addr uint16) bool {
+	if addr >= AddrAllProxies {
+		return true
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if table.groups[addr] {
+		return true
+	}
+	return table.occupiedLocked(addr)
+}
+
+// occupied reports if addr belongs to an elem of a known node
+func (table *nodeTable) occupied(addr uint16) bool {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.occupiedLocked(addr)
+}
+
+// occupiedLocked is occupied for callers holding table.mu
+func (table *nodeTable) occupiedLocked(addr uint16) bool {
+	for _, node := range table.nodes {
+		// Nodes added without an elem count are assumed to have one
+		elements := uint16(node.Elements)
+		if elements == 0 {
+			elements = 1
+		}
+		if addr >= node.Addr && addr < node.Addr+elements {
+			return true
+		}
+	}
+	return false
+}
+
+// addedElements returns the elem count of an OpNodeAdded frame, zero if the firmware does not send it
+// The frame is OpNodeAdded, the primary addr then the elem count
+func addedElements(frame []byte) uint8 {
+	if len(frame) < 4 {
+		return 0
+	}
+	return frame[3]
+}
+
+// add records a node at addr with the given elem count
+func (table *nodeTable) add(addr uint16, elements uint8) {
+	table.mu.Lock()
+	table.nodes[addr] = NodeInfo{Addr: addr, Elements: elements}
+	table.mu.Unlock()
+}
+
+// remove forgets the node at addr
+func (table *nodeTable) remove(addr uint16) {
+	table.mu.Lock()
+	delete(table.nodes, addr)
+	table.mu.Unlock()
+}
+
+// get returns the node known at addr
+func (table *nodeTable) get(addr uint16) (NodeInfo, bool) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	node, ok := table.nodes[addr]
+	return node, ok
+}
+
+// clear forgets every node
+func (table *nodeTable) clear() {
+	table.mu.Lock()
+	table.nodes = make(map[uint16]NodeInfo)
+	table.groups = make(map[uint16]bool)
+	table.mu.Unlock()
+}
+
+// replace sets the known nodes to nodes
+func (table *nodeTable) replace(nodes []NodeInfo) {
+	table.mu.Lock()
+	table.nodes = make(map[uint16]NodeInfo, len(nodes))
+	for _, node := range nodes {
+		table.nodes[node.Addr] = node
+	}
+	table.mu.Unlock()
+}
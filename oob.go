@@ -0,0 +1,88 @@
+package mesh
+
+import "encoding/binary"
+
+// Capabilities are what a device being provisioned reports it supports
+// The OOB action fields are bit masks of the actions from the mesh spec
+type Capabilities struct {
+	Elements         uint8
+	Algorithms       uint16
+	PublicKeyType    uint8
+	StaticOOBType    uint8
+	OutputOOBSize    uint8
+	OutputOOBActions uint16
+	InputOOBSize     uint8
+	InputOOBActions  uint16
+}
+
+// OOBMethod is how a device being provisioned is authenticated
+type OOBMethod byte
+
+// OOB methods from the mesh spec
+const (
+	OOBNone   OOBMethod = 0x00
+	OOBStatic OOBMethod = 0x01
+	OOBOutput OOBMethod = 0x02
+	OOBInput  OOBMethod = 0x03
+)
+
+// OOBChoice picks the OOB method used to authenticate a device
+// Action is the output or input action and Size the number of digits or characters, both are zero for OOBNone and OOBStatic
+type OOBChoice struct {
+	Method OOBMethod
+	Action uint8
+	Size   uint8
+}
+
+// negotiate answers an OpCapabilities frame using OnProvisioningCapabilities
+// The frame is OpCapabilities then the capabilities pdu which is big endian like all provisioning pdus
+// It runs on the Read goroutine so the callback must not block
+// An abort or a failed reply ends the provisioning in progress with that error
+func (controller *Controller) negotiate(frame []byte) {
+	caps := Capabilities{
+		Elements:         frame[1],
+		Algorithms:       binary.BigEndian.Uint16(frame[2:4]),
+		PublicKeyType:    frame[4],
+		StaticOOBType:    frame[5],
+		OutputOOBSize:    frame[6],
+		OutputOOBActions: binary.BigEndian.Uint16(frame[7:9]),
+		InputOOBSize:     frame[9],
+		InputOOBActions:  binary.BigEndian.Uint16(frame[10:12]),
+	}
+	choice := OOBChoice{Method: OOBNone}
+	if controller.OnProvisioningCapabilities != nil {
+		var ok bool
+		choice, ok = controller.OnProvisioningCapabilities(caps)
+		if !ok {
+			err := controller.WriteData([]byte{OpAbortProvisioning})
+			if err == nil {
+				err = ErrProvisioningAborted
+			}
+			controller.failProvisioning(err)
+			return
+		}
+	}
+	err := controller.WriteData([]byte{OpStartProvisioning, byte(choice.Method), choice.Action, choice.Size})
+	if err != nil {
+		controller.failProvisioning(err)
+	}
+}
+
+// failProvisioning clears busy and hands err to the request waiting for the device to be added
+func (controller *Controller) failProvisioning(err error) {
+	controller.busy.clear()
+	select {
+	case controller.provisionFailed <- err:
+	default:
+	}
+}
+
+// EnterOOB gives the Mesh Controller the auth value for the device being provisioned
+// For OOBOutput it is the value the device shows, for OOBInput the value the user enters on the device
+// For OOBStatic it is the 16 byte static OOB data
+// Numbers are sent as big endian bytes and strings as ascii
+func (controller *Controller) EnterOOB(value []byte) error {
+	parms := []byte{OpEnterOOB}
+	parms = append(parms, value...)
+	return controller.WriteData(parms)
+}
@@ -0,0 +1,157 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// provisionTimeout is how long a device has to be added once provisioning starts
+const provisionTimeout = 30 * time.Second
+
+// ProvisionStage is how far a device in a ProvisionQueue has got
+type ProvisionStage int
+
+// Stages reported by a ProvisionQueue
+const (
+	ProvisionStarted ProvisionStage = iota
+	ProvisionSucceeded
+	ProvisionFailed
+)
+
+// ProvisionProgress reports a device reaching a stage, Addr and Elements are set on success and Err on failure
+// Elements is zero when the firmware does not report the elem count
+type ProvisionProgress struct {
+	UUID     []byte
+	Stage    ProvisionStage
+	Addr     uint16
+	Elements uint8
+	Err      error
+}
+
+// ProvisionQueue provisions submitted devices one after another
+// Read must be running so the queue learns when each device has been added
+type ProvisionQueue struct {
+	controller *Controller
+	mu         sync.Mutex
+	uuids      [][]byte
+	wake       chan struct{}
+	progress   chan ProvisionProgress
+}
+
+// NewProvisionQueue starts a queue that provisions devices until ctx is done
+func (controller *Controller) NewProvisionQueue(ctx context.Context) *ProvisionQueue {
+	queue := &ProvisionQueue{
+		controller: controller,
+		wake:       make(chan struct{}, 1),
+		progress:   make(chan ProvisionProgress),
+	}
+	go queue.run(ctx)
+	return queue
+}
+
+// Submit adds the device with the given uuid to the end of the queue
+func (queue *ProvisionQueue) Submit(uuid []byte) {
+	queue.mu.Lock()
+	queue.uuids = append(queue.uuids, uuid)
+	queue.mu.Unlock()
+	// Wake the queue if it is idle
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Progress returns the channel that receives each stage of every submitted device
+// It must be drained for the queue to move on and is closed when the queue stops
+func (queue *ProvisionQueue) Progress() <-chan ProvisionProgress {
+	return queue.progress
+}
+
+// run provisions queued devices one at a time as the PB-ADV link only serves one device
+func (queue *ProvisionQueue) run(ctx context.Context) {
+	defer close(queue.progress)
+	for {
+		uuid := queue.next()
+		if uuid == nil {
+			select {
+			case <-queue.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !queue.report(ctx, ProvisionProgress{UUID: uuid, Stage: ProvisionStarted}) {
+			return
+		}
+		node, err := queue.controller.provisionAndWait(ctx, uuid)
+		result := ProvisionProgress{UUID: uuid, Stage: ProvisionSucceeded, Addr: node.Addr, Elements: node.Elements}
+		if err != nil {
+			result = ProvisionProgress{UUID: uuid, Stage: ProvisionFailed, Err: err}
+		}
+		if !queue.report(ctx, result) {
+			return
+		}
+	}
+}
+
+// next pops the oldest queued uuid or returns nil if the queue is empty
+func (queue *ProvisionQueue) next() []byte {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if len(queue.uuids) == 0 {
+		return nil
+	}
+	uuid := queue.uuids[0]
+	queue.uuids = queue.uuids[1:]
+	return uuid
+}
+
+// report sends progress unless ctx is done first
+func (queue *ProvisionQueue) report(ctx context.Context, progress ProvisionProgress) bool {
+	select {
+	case queue.progress <- progress:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// provisionAndWait provisions the device with the given uuid and returns the addr and elem count it was given
+// The node added frame is still passed to the onNodeAdded handler
+func (controller *Controller) provisionAndWait(ctx context.Context, uuid []byte) (NodeInfo, error) {
+	if len(uuid) != 16 {
+		return NodeInfo{}, errors.New("Invalid uuid")
+	}
+	ctx, cancel := context.WithTimeout(ctx, provisionTimeout)
+	defer cancel()
+	err := controller.checkReader()
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	// Drop a failure left from a device provisioned without waiting
+	select {
+	case <-controller.provisionFailed:
+	default:
+	}
+	// Node added frames are not tied to an addr so wait on the unassigned addr
+	w := controller.pending.add(waitKey{op: OpNodeAdded})
+	defer controller.pending.remove(w)
+	err = controller.Provision(uuid)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	var reply []byte
+	select {
+	case reply = <-w.frame:
+	case err = <-controller.provisionFailed:
+		return NodeInfo{}, err
+	case <-ctx.Done():
+		// Stop the controller provisioning so it takes other commands again
+		controller.AbortProvisioning()
+		controller.busy.clear()
+		return NodeInfo{}, ctx.Err()
+	}
+	return NodeInfo{Addr: fromByteSlice(reply[1:3]), Elements: addedElements(reply)}, nil
+}
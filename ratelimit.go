@@ -0,0 +1,40 @@
+package mesh
+
+import "time"
+
+// rateLimiter is a token bucket used to pace writes to the Mesh Controller
+// The bucket holds a single token so a burst of writes is sent evenly spaced
+type rateLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait blocks until a token is available when refilling at the given rate per second
+// It returns ErrClosed without taking a token if closing is done first
+func (limiter *rateLimiter) wait(rate int, closing <-chan struct{}) error {
+	now := time.Now()
+	// Refill tokens for the time since the last write
+	if limiter.last.IsZero() {
+		limiter.tokens = 1
+	} else {
+		limiter.tokens += now.Sub(limiter.last).Seconds() * float64(rate)
+		if limiter.tokens > 1 {
+			limiter.tokens = 1
+		}
+	}
+	limiter.last = now
+	// Wait until a whole token is available
+	if limiter.tokens < 1 {
+		timer := time.NewTimer(time.Duration((1 - limiter.tokens) / float64(rate) * float64(time.Second)))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-closing:
+			return ErrClosed
+		}
+		limiter.tokens = 1
+		limiter.last = time.Now()
+	}
+	limiter.tokens--
+	return nil
+}
@@ -0,0 +1,17 @@
+package mesh
+
+import "testing"
+
+func TestRateLimiterWaitStopsOnClose(t *testing.T) {
+	limiter := &rateLimiter{}
+	closing := make(chan struct{})
+	// The first write takes the only token
+	if err := limiter.wait(1, closing); err != nil {
+		t.Fatalf("first wait failed: %v", err)
+	}
+	// The next write would sleep for a second unless closing stops it
+	close(closing)
+	if err := limiter.wait(1, closing); err != ErrClosed {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,85 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+)
+
+// recallAttempts is how many times RecallAndVerify recalls a scene before giving up
+const recallAttempts = 3
+
+// GetScene reads the current scene of the node with the given addr using the app key at the given index
+// The current scene is 0 while the node is in a transition to another scene
+func (controller *Controller) GetScene(ctx context.Context, addr uint16, appIdx uint16) (uint16, error) {
+	current, _, err := controller.getSceneStatus(ctx, addr, appIdx)
+	return current, err
+}
+
+// getSceneStatus reads the current and target scene of the node with the given addr
+// The target scene is 0 when the node is not in a transition or the firmware does not send it
+func (controller *Controller) getSceneStatus(ctx context.Context, addr uint16, appIdx uint16) (uint16, uint16, error) {
+	parms := []byte{OpGetScene}
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpSceneStatus, addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Reply is addr, status, current scene then target scene while in a transition
+	if reply[3] != 0 {
+		return 0, 0, errors.New("Scene request failed")
+	}
+	var target uint16
+	if len(reply) >= 8 {
+		target = fromByteSlice(reply[6:8])
+	}
+	return fromByteSlice(reply[4:6]), target, nil
+}
+
+// RecallAndVerify recalls a scene on the given unicast addr and reads the scene back to confirm it changed
+// A node in a transition to the scene counts as recalled
+// The recall is retried up to recallAttempts times before the last error is returned
+// Group addrs are rejected as the scene can only be read back from each node
+func (controller *Controller) RecallAndVerify(ctx context.Context, sceneNumber uint16, addr uint16, appIdx uint16) error {
+	if addr == 0 || addr > 0x7FFF {
+		return errors.New("Scene can only be verified on a unicast address")
+	}
+	err := errors.New("Scene was not recalled")
+	for attempt := 0; attempt < recallAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = controller.SendRecallMessage(sceneNumber, addr, appIdx)
+		if err != nil {
+			if !retryableRecall(err) {
+				return err
+			}
+			continue
+		}
+		// Bound each check so a lost reply leaves time to retry
+		checkCtx, cancel := context.WithTimeout(ctx, responseTimeout)
+		current, target, getErr := controller.getSceneStatus(checkCtx, addr, appIdx)
+		cancel()
+		if getErr != nil {
+			if !retryableRecall(getErr) {
+				return getErr
+			}
+			err = getErr
+			continue
+		}
+		if current == sceneNumber || target == sceneNumber {
+			return nil
+		}
+		err = errors.New("Scene was not recalled")
+	}
+	return err
+}
+
+// retryableRecall reports if a recall or check that failed with err may succeed when tried again
+func retryableRecall(err error) bool {
+	switch err {
+	case ErrClosed, ErrOpcodeDisabled, ErrControllerNotFound, ErrUnknownNode, ErrNoReader:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+)
+
+// ScheduleAction is what a node does when a schedule entry fires
+type ScheduleAction byte
+
+// Schedule actions from the mesh spec
+const (
+	ScheduleTurnOff     ScheduleAction = 0x0
+	ScheduleTurnOn      ScheduleAction = 0x1
+	ScheduleSceneRecall ScheduleAction = 0x2
+	ScheduleNoAction    ScheduleAction = 0xF
+)
+
+// Special values for the fields of a ScheduleEntry
+const (
+	ScheduleAnyYear    = 0x64
+	ScheduleAnyDay     = 0x00
+	ScheduleAnyHour    = 0x18
+	ScheduleRandomHour = 0x19
+	ScheduleAnyMinute  = 0x3C
+	ScheduleEvery15    = 0x3D
+	ScheduleEvery20    = 0x3E
+	ScheduleRandom     = 0x3F
+	ScheduleAnySecond  = ScheduleAnyMinute
+)
+
+// ScheduleEntry is an entry of a node's schedule register
+type ScheduleEntry struct {
+	// Year is the last two digits of the year or ScheduleAnyYear
+	Year uint8
+	// Month is a bit mask of months with bit 0 for January
+	Month uint16
+	// Day is the day of the month or ScheduleAnyDay
+	Day uint8
+	// Hour is 0 to 23, ScheduleAnyHour or ScheduleRandomHour
+	Hour uint8
+	// Minute and Second are 0 to 59 or one of the Any, Every and Random values
+	Minute uint8
+	Second uint8
+	// DayOfWeek is a bit mask of days with bit 0 for Monday
+	DayOfWeek      uint8
+	Action         ScheduleAction
+	TransitionTime uint8
+	SceneNumber    uint16
+}
+
+// SetSchedule sends a bt mesh scheduler action set message using the app key at the given index to the given addr
+// The entry is stored at index which is 0 to 15
+func (controller *Controller) SetSchedule(addr uint16, appIdx uint16, index uint8, entry ScheduleEntry) error {
+	if index > 0x0F {
+		return errors.New("Invalid schedule index")
+	}
+	err := controller.checkDest(addr)
+	if err != nil {
+		return err
+	}
+	parms := []byte{OpSendScheduleMessage}
+	parms = append(parms, encodeSchedule(index, entry)...)
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	return controller.WriteData(parms)
+}
+
+// GetScheduleRegister reads the entry at index of the schedule register of the node with the given addr using the app key at the given index
+func (controller *Controller) GetScheduleRegister(ctx context.Context, addr uint16, appIdx uint16, index uint8) (ScheduleEntry, error) {
+	if index > 0x0F {
+		return ScheduleEntry{}, errors.New("Invalid schedule index")
+	}
+	parms := []byte{OpGetSchedule}
+	parms = append(parms, index)
+	parms = append(parms, toByteSlice(addr)...)
+	parms = append(parms, toByteSlice(appIdx)...)
+	reply, err := controller.request(ctx, parms, OpScheduleStatus, addr)
+	if err != nil {
+		return ScheduleEntry{}, err
+	}
+	replyIndex, entry := decodeSchedule(reply[3:])
+	if replyIndex != index {
+		return ScheduleEntry{}, errors.New("Schedule reply for wrong index")
+	}
+	return entry, nil
+}
+
+// scheduleFields are the bit widths of the packed scheduler action in order from the lowest bit
+var scheduleFields = []uint{4, 7, 12, 5, 5, 6, 6, 7, 4, 8, 16}
+
+// encodeSchedule packs an index and entry into the 10 byte scheduler action
+func encodeSchedule(index uint8, entry ScheduleEntry) []byte {
+	values := []uint64{
+		uint64(index), uint64(entry.Year), uint64(entry.Month), uint64(entry.Day),
+		uint64(entry.Hour), uint64(entry.Minute), uint64(entry.Second), uint64(entry.DayOfWeek),
+		uint64(entry.Action), uint64(entry.TransitionTime), uint64(entry.SceneNumber),
+	}
+	action := make([]byte, 10)
+	var offset uint
+	for i, width := range scheduleFields {
+		for bit := uint(0); bit < width; bit++ {
+			if values[i]>>bit&1 == 1 {
+				action[(offset+bit)/8] |= 1 << ((offset + bit) % 8)
+			}
+		}
+		offset += width
+	}
+	return action
+}
+
+// decodeSchedule unpacks a 10 byte scheduler action into its index and entry
+func decodeSchedule(action []byte) (uint8, ScheduleEntry) {
+	values := make([]uint64, len(scheduleFields))
+	var offset uint
+	for i, width := range scheduleFields {
+		for bit := uint(0); bit < width; bit++ {
+			if action[(offset+bit)/8]>>((offset+bit)%8)&1 == 1 {
+				values[i] |= 1 << bit
+			}
+		}
+		offset += width
+	}
+	return uint8(values[0]), ScheduleEntry{
+		Year:           uint8(values[1]),
+		Month:          uint16(values[2]),
+		Day:            uint8(values[3]),
+		Hour:           uint8(values[4]),
+		Minute:         uint8(values[5]),
+		Second:         uint8(values[6]),
+		DayOfWeek:      uint8(values[7]),
+		Action:         ScheduleAction(values[8]),
+		TransitionTime: uint8(values[9]),
+		SceneNumber:    uint16(values[10]),
+	}
+}
@@ -0,0 +1,32 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+)
+
+// maxSequence is the largest 24 bit sequence number
+const maxSequence = 0xFFFFFF
+
+// GetSequenceNumber reads the sequence number the Mesh Controller will send its next message with
+// Store it before a Reboot and restore it with SetSequenceNumber so nodes do not drop messages as replays
+// Read must be running for the reply to be received
+func (controller *Controller) GetSequenceNumber() (uint32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+	reply, err := controller.request(ctx, []byte{OpGetSequence}, OpSequenceStatus, 0)
+	if err != nil {
+		return 0, err
+	}
+	// Reply is the 24 bit sequence number
+	return uint32(reply[1]) | uint32(reply[2])<<8 | uint32(reply[3])<<16, nil
+}
+
+// SetSequenceNumber sets the sequence number the Mesh Controller sends its next message with
+// It must not be lower than any sequence number already used or nodes drop the messages as replays
+func (controller *Controller) SetSequenceNumber(seq uint32) error {
+	if seq > maxSequence {
+		return errors.New("Invalid sequence number")
+	}
+	return controller.WriteData([]byte{OpSetSequence, byte(seq), byte(seq >> 8), byte(seq >> 16)})
+}
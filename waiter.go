@@ -0,0 +1,122 @@
+package mesh
+
+import (
+	"sync"
+	"time"
+)
+
+// responseTimeout is how long helpers without a context wait for a reply
+const responseTimeout = 2 * time.Second
+
+// waitKey matches a reply by its op code and the addr it came from
+type waitKey struct {
+	op   byte
+	addr uint16
+}
+
+// streamBuffer is how many frames a stream waiter can hold before frames are dropped
+const streamBuffer = 64
+
+// waiter receives a reply frame from the read loop
+// A stream waiter receives every matching frame until it is removed
+type waiter struct {
+	key    waitKey
+	frame  chan []byte
+	stream bool
+}
+
+// pendingReplies holds the waiters registered by request helpers
+type pendingReplies struct {
+	mu      sync.Mutex
+	waiters map[waitKey][]*waiter
+}
+
+func newPendingReplies() *pendingReplies {
+	return &pendingReplies{waiters: make(map[waitKey][]*waiter)}
+}
+
+// add registers a waiter for the next frame matching key
+func (pending *pendingReplies) add(key waitKey) *waiter {
+	w := &waiter{key: key, frame: make(chan []byte, 1)}
+	pending.mu.Lock()
+	pending.waiters[key] = append(pending.waiters[key], w)
+	pending.mu.Unlock()
+	return w
+}
+
+// addStream registers a waiter for every frame matching key
+func (pending *pendingReplies) addStream(key waitKey) *waiter {
+	w := &waiter{key: key, frame: make(chan []byte, streamBuffer), stream: true}
+	pending.mu.Lock()
+	pending.waiters[key] = append(pending.waiters[key], w)
+	pending.mu.Unlock()
+	return w
+}
+
+// remove unregisters a waiter if it is still pending
+// A reply the waiter caught after its request gave up is dropped with it
+// as it most likely answers that request and not a reissued one
+func (pending *pendingReplies) remove(w *waiter) {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	waiters := pending.waiters[w.key]
+	for i := range waiters {
+		if waiters[i] == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(pending.waiters, w.key)
+	} else {
+		pending.waiters[w.key] = waiters
+	}
+}
+
+// deliver hands frame to every stream waiter and the oldest other waiter for its key and reports if one took it
+func (pending *pendingReplies) deliver(frame []byte) bool {
+	key := waitKey{op: frame[0], addr: sourceAddr(frame)}
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	waiters := pending.waiters[key]
+	if len(waiters) == 0 {
+		return false
+	}
+	// Stream waiters stay registered and drop frames they have no room for
+	var once *waiter
+	kept := waiters[:0:0]
+	for _, w := range waiters {
+		if w.stream {
+			select {
+			case w.frame <- frame:
+			default:
+			}
+			kept = append(kept, w)
+			continue
+		}
+		// Other waiters are removed once they receive a frame
+		if once == nil {
+			once = w
+			w.frame <- frame
+			continue
+		}
+		kept = append(kept, w)
+	}
+	if len(kept) == 0 {
+		delete(pending.waiters, key)
+	} else {
+		pending.waiters[key] = kept
+	}
+	return true
+}
+
+// sourceAddr returns the addr of the node a frame came from, frames from the controller itself return 0
+func sourceAddr(frame []byte) uint16 {
+	switch frame[0] {
+	case OpState, OpEvent, OpNodeIdentityStatus, OpSubscriptionList, OpFaultStatus, OpHSLStatus, OpMonitorFrame, OpTimeStatus, OpScheduleStatus, OpSceneStatus, OpNodeFeatures, OpConfigureNodeStatus, OpConfigureElemStatus, OpModelKeyList, OpVendorModelKeyList, OpBatteryStatus:
+		if len(frame) >= 3 {
+			return fromByteSlice(frame[1:3])
+		}
+	}
+	return 0
+}
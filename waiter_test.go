@@ -0,0 +1,111 @@
+package mesh
+
+import "testing"
+
+func TestCancelledWaiterDoesNotGetReissuedReply(t *testing.T) {
+	pending := newPendingReplies()
+	key := waitKey{op: OpSceneStatus, addr: 0x0005}
+	// Fire a request then cancel it before its reply arrives
+	first := pending.add(key)
+	pending.remove(first)
+	// Reissue and deliver the reply for the second request
+	second := pending.add(key)
+	reply := []byte{OpSceneStatus, 0x05, 0x00, 0x00, 0x02, 0x00}
+	if !pending.deliver(reply) {
+		t.Fatal("reply was not delivered")
+	}
+	select {
+	case <-first.frame:
+		t.Fatal("cancelled waiter received the reply")
+	default:
+	}
+	select {
+	case frame := <-second.frame:
+		if frame[4] != 0x02 {
+			t.Fatalf("got frame %v", frame)
+		}
+	default:
+		t.Fatal("reissued waiter did not receive the reply")
+	}
+	pending.remove(second)
+	if len(pending.waiters) != 0 {
+		t.Fatalf("waiters leaked: %v", pending.waiters)
+	}
+}
+
+func TestLateReplyDroppedWithCancelledWaiter(t *testing.T) {
+	pending := newPendingReplies()
+	key := waitKey{op: OpSceneStatus, addr: 0x0005}
+	// The reply reaches the first waiter just as its request gives up
+	first := pending.add(key)
+	pending.deliver([]byte{OpSceneStatus, 0x05, 0x00, 0x00, 0x01, 0x00})
+	second := pending.add(key)
+	pending.remove(first)
+	select {
+	case frame := <-second.frame:
+		t.Fatalf("reissued waiter received the cancelled reply %v", frame)
+	default:
+	}
+	pending.remove(second)
+	if len(pending.waiters) != 0 {
+		t.Fatalf("waiters leaked: %v", pending.waiters)
+	}
+}
+
+func TestStreamWaitersAllReceiveFrames(t *testing.T) {
+	pending := newPendingReplies()
+	key := waitKey{op: OpNodeInfo}
+	first := pending.addStream(key)
+	second := pending.addStream(key)
+	frame := []byte{OpNodeInfo, 0x00, 0x00, 0x01, 0x00, 0x05, 0x00, 0x01}
+	if !pending.deliver(frame) {
+		t.Fatal("frame was not delivered")
+	}
+	for i, w := range []*waiter{first, second} {
+		select {
+		case <-w.frame:
+		default:
+			t.Fatalf("stream waiter %d did not receive the frame", i)
+		}
+	}
+	pending.remove(first)
+	pending.remove(second)
+	if len(pending.waiters) != 0 {
+		t.Fatalf("waiters leaked: %v", pending.waiters)
+	}
+}
+
+func TestOneShotAndStreamWaitersShareFrame(t *testing.T) {
+	pending := newPendingReplies()
+	key := waitKey{op: OpSceneStatus, addr: 0x0005}
+	stream := pending.addStream(key)
+	first := pending.add(key)
+	second := pending.add(key)
+	frame := []byte{OpSceneStatus, 0x05, 0x00, 0x00, 0x01, 0x00}
+	pending.deliver(frame)
+	// The stream and the oldest request get the frame, the newer request waits for the next one
+	for name, w := range map[string]*waiter{"stream": stream, "oldest": first} {
+		select {
+		case <-w.frame:
+		default:
+			t.Fatalf("%s waiter did not receive the frame", name)
+		}
+	}
+	select {
+	case <-second.frame:
+		t.Fatal("newer waiter received the frame")
+	default:
+	}
+	pending.deliver(frame)
+	select {
+	case <-second.frame:
+	default:
+		t.Fatal("newer waiter did not receive the next frame")
+	}
+	pending.remove(stream)
+	pending.remove(first)
+	pending.remove(second)
+	if len(pending.waiters) != 0 {
+		t.Fatalf("waiters leaked: %v", pending.waiters)
+	}
+}
@@ -0,0 +1,50 @@
+package mesh
+
+import "sync"
+
+// handlerPool runs handlers on a fixed number of goroutines while Read runs
+type handlerPool struct {
+	mu      sync.Mutex
+	jobs    chan func()
+	workers sync.WaitGroup
+}
+
+// start runs workers goroutines that take handlers off the queue
+func (pool *handlerPool) start(workers int) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.jobs = make(chan func(), streamBuffer)
+	for i := 0; i < workers; i++ {
+		pool.workers.Add(1)
+		go func(jobs chan func()) {
+			defer pool.workers.Done()
+			for job := range jobs {
+				job()
+			}
+		}(pool.jobs)
+	}
+}
+
+// stop waits for queued handlers to finish and makes handlers run inline again
+func (pool *handlerPool) stop() {
+	pool.mu.Lock()
+	close(pool.jobs)
+	pool.jobs = nil
+	pool.mu.Unlock()
+	pool.workers.Wait()
+}
+
+// run calls fn with frame on a worker or inline if no workers are running
+// A full queue blocks until a worker is free
+func (pool *handlerPool) run(fn func(frame []byte), frame []byte) {
+	pool.mu.Lock()
+	jobs := pool.jobs
+	pool.mu.Unlock()
+	if jobs == nil {
+		fn(frame)
+		return
+	}
+	jobs <- func() {
+		fn(frame)
+	}
+}